@@ -0,0 +1,123 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+// activeAcceptTimeout is how long the client waits for the server to connect
+// in active mode, unless Dialer.Timeout is set.
+const activeAcceptTimeout = 30 * time.Second
+
+// pendingDataConn is a data connection being opened: dialed already in
+// passive mode, or waited for on a listener in active mode.
+type pendingDataConn struct {
+	c    *ServerConn
+	conn net.Conn
+	ln   net.Listener
+}
+
+// establish returns the data connection, accepting it in active mode. It
+// must be called once the transfer command has been accepted by the server.
+func (p *pendingDataConn) establish() (net.Conn, error) {
+	if p.ln == nil {
+		return p.conn, nil
+	}
+	defer p.ln.Close()
+
+	timeout := p.c.dialTimeout
+	if timeout == 0 {
+		timeout = activeAcceptTimeout
+	}
+	if ln, ok := p.ln.(*net.TCPListener); ok {
+		ln.SetDeadline(time.Now().Add(timeout))
+	}
+	conn, err := p.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return p.c.setupDataConn(conn)
+}
+
+// Close implements the io.Closer interface, when the transfer command fails.
+func (p *pendingDataConn) Close() error {
+	if p.ln != nil {
+		return p.ln.Close()
+	}
+	return p.conn.Close()
+}
+
+// listenActive listens on the address of the control connection, in the
+// range of ActivePortMin and ActivePortMax, and advertises the port to the
+// server with PORT, or with EPRT for IPv6.
+func (c *ServerConn) listenActive() (net.Listener, error) {
+	local, ok := c.netConn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("ftp: active mode requires a TCP control connection")
+	}
+
+	ln, err := listenPortRange(local.IP, c.ActivePortMin, c.ActivePortMax)
+	if err != nil {
+		return nil, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	advertised, err := c.externalIP(local.IP)
+	if err == nil {
+		if ip := advertised.To4(); ip != nil {
+			_, _, err = c.exchange(StatusCommandOK, "PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+		} else {
+			// EPRT is described in RFC 2428
+			_, _, err = c.exchange(StatusCommandOK, "EPRT |2|%s|%d|", advertised, port)
+		}
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// externalIP returns the address advertised in active mode: ActiveExternalIP,
+// discovered with DiscoverExternalIP if needed, or local.
+func (c *ServerConn) externalIP(local net.IP) (net.IP, error) {
+	if c.ActiveExternalIP == nil && c.DiscoverExternalIP != nil {
+		ip, err := c.DiscoverExternalIP()
+		if err != nil {
+			return nil, err
+		}
+		c.ActiveExternalIP = ip
+	}
+	if c.ActiveExternalIP != nil {
+		return c.ActiveExternalIP, nil
+	}
+	return local, nil
+}
+
+// listenPortRange listens on ip, on the first free port of the range from
+// min to max starting at a random one, or on any port if both are zero.
+func listenPortRange(ip net.IP, min, max int) (net.Listener, error) {
+	if min == 0 && max == 0 {
+		return net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	}
+	if min <= 0 || max > 65535 || max < min {
+		return nil, fmt.Errorf("ftp: invalid port range %d-%d", min, max)
+	}
+
+	n := max - min + 1
+	start := rand.Intn(n)
+	var err error
+	for i := 0; i < n; i++ {
+		port := min + (start+i)%n
+		var ln net.Listener
+		ln, err = net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+	}
+	return nil, err
+}
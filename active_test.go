@@ -0,0 +1,152 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// activeServer serves a single NLST in active mode on the connections of ln.
+func activeServer(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 ready")
+
+	var dataAddr string
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		switch fields := strings.Fields(line); fields[0] {
+		case "PORT":
+			var h [6]int
+			fmt.Sscanf(fields[1], "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &h[4], &h[5])
+			dataAddr = fmt.Sprintf("%d.%d.%d.%d:%d", h[0], h[1], h[2], h[3], h[4]<<8|h[5])
+			tc.PrintfLine("200 PORT ok")
+		case "NLST":
+			tc.PrintfLine("150 opening data connection")
+			data, err := net.Dial("tcp", dataAddr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			data.Write([]byte("a\r\nb\r\n"))
+			data.Close()
+			tc.PrintfLine("226 done")
+		default:
+			tc.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestActiveMode(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go activeServer(t, ln)
+
+	var hooked []bool
+	hook := func(conn *net.TCPConn, data bool) error {
+		hooked = append(hooked, data)
+		return conn.SetNoDelay(true)
+	}
+	c, err := Dial(ln.Addr().String(), DialWithActiveMode(0, 0), DialWithSocketHook(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	names, err := c.NameList(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("NameList() = %v, want [a b]", names)
+	}
+	if len(hooked) != 2 || hooked[0] || !hooked[1] {
+		t.Errorf("SocketHook called with data = %v, want [false true]", hooked)
+	}
+}
+
+func TestActiveModeAcceptError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// the server never connects to the client
+		serveScript(conn, "220 ready", map[string]string{
+			"PORT": "200 PORT ok",
+			"NLST": "150 opening data connection\r\n425 can't open data connection",
+			"NOOP": "200 ok",
+		}, nil)
+	}()
+
+	c, err := Dial(ln.Addr().String(), DialWithActiveMode(0, 0), DialWithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.NameList("."); err == nil {
+		t.Error("NameList() succeeded without a data connection")
+	}
+	if code := c.LastReply().Code; code != StatusCanNotOpenDataConnection {
+		t.Errorf("LastReply().Code = %d, want %d", code, StatusCanNotOpenDataConnection)
+	}
+	if err := c.NoOp(); err != nil {
+		t.Errorf("NoOp() after the failed transfer = %v", err)
+	}
+}
+
+func TestListenPortRange(t *testing.T) {
+	ln, err := listenPortRange(net.ParseIP("127.0.0.1"), 40000, 40010)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	if port := ln.Addr().(*net.TCPAddr).Port; port < 40000 || port > 40010 {
+		t.Errorf("listening on port %d, want 40000-40010", port)
+	}
+
+	if _, err := listenPortRange(nil, 2000, 1000); err == nil {
+		t.Error("listenPortRange() accepted an empty range")
+	}
+}
+
+func TestExternalIP(t *testing.T) {
+	local := net.ParseIP("10.0.0.2")
+	c := &ServerConn{}
+	if ip, _ := c.externalIP(local); !ip.Equal(local) {
+		t.Errorf("externalIP() = %v, want the local address", ip)
+	}
+
+	calls := 0
+	c.DiscoverExternalIP = func() (net.IP, error) {
+		calls++
+		return net.ParseIP("198.51.100.7"), nil
+	}
+	for i := 0; i < 2; i++ {
+		if ip, err := c.externalIP(local); err != nil || ip.String() != "198.51.100.7" {
+			t.Errorf("externalIP() = %v, %v, want 198.51.100.7", ip, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("DiscoverExternalIP called %d times, want once", calls)
+	}
+}
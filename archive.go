@@ -0,0 +1,251 @@
+package ftp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ArchiveFormat is the format of the archives written by Archive.
+type ArchiveFormat int
+
+// The archive formats
+const (
+	ArchiveTar ArchiveFormat = iota // tar, uncompressed
+	ArchiveZip                      // zip, deflated
+)
+
+// archiveWriter is the part of tar.Writer and zip.Writer used by Archive.
+type archiveWriter interface {
+	// add adds an entry and returns the writer of its content
+	add(name string, info os.FileInfo) (io.Writer, error)
+	Close() error
+}
+
+// Archive walks the remote tree rooted at remoteDir like Walk and streams
+// its directories and files into an archive written to w, as they are
+// downloaded. The names in the archive are relative to remoteDir. Entries
+// other than regular files and directories are skipped.
+func (c *ServerConn) Archive(remoteDir string, w io.Writer, format ArchiveFormat) error {
+	var aw archiveWriter
+	switch format {
+	case ArchiveTar:
+		aw = tarWriter{tar.NewWriter(w)}
+	case ArchiveZip:
+		aw = zipWriter{zip.NewWriter(w)}
+	default:
+		return errors.New("ftp: unknown archive format")
+	}
+
+	p := c.newProgress("Archive")
+	err := c.walkTree(remoteDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == remoteDir {
+			return nil
+		}
+		name := relativePath(remoteDir, path)
+
+		switch {
+		case info.IsDir():
+			if _, err = aw.add(name+"/", info); err != nil {
+				return err
+			}
+			p.done(path, 0)
+		case info.Mode().IsRegular():
+			dst, err := aw.add(name, info)
+			if err != nil {
+				return err
+			}
+			// the exact content, whatever ASCIIExtensions
+			r, err := c.retr("I", path, 0)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(dst, r)
+			if err2 := r.Close(); err == nil {
+				err = err2
+			}
+			if err != nil {
+				return err
+			}
+			p.done(path, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// relativePath returns the path of a file walked under root, as built by
+// walk, relative to root.
+func relativePath(root, name string) string {
+	switch root = path.Clean(root); root {
+	case ".":
+		return name
+	case "/":
+		return strings.TrimPrefix(name, "/")
+	}
+	return strings.TrimPrefix(name, root+"/")
+}
+
+// tarWriter adds the entries of Archive to a tar archive.
+type tarWriter struct {
+	*tar.Writer
+}
+
+func (w tarWriter) add(name string, info os.FileInfo) (io.Writer, error) {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if err := w.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return w.Writer, nil
+}
+
+// zipWriter adds the entries of Archive to a zip archive.
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (w zipWriter) add(name string, info os.FileInfo) (io.Writer, error) {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if !info.IsDir() {
+		hdr.Method = zip.Deflate
+	}
+	return w.CreateHeader(hdr)
+}
+
+// unarchiveSpoolMemory is the size up to which Unarchive spools a zip
+// archive in memory rather than in a temporary file.
+const unarchiveSpoolMemory = 8 << 20
+
+// Unarchive reads an archive from r and expands it into the remote directory
+// remoteDir, creating the directories and uploading the files as they are
+// read. A zip archive, which can not be read as a stream, is spooled first.
+// Entries other than regular files and directories are skipped, and entries
+// whose name points outside of remoteDir are rejected.
+func (c *ServerConn) Unarchive(r io.Reader, remoteDir string, format ArchiveFormat) error {
+	u := &unarchiver{c: c, root: remoteDir, dirs: make(map[string]bool), p: c.newProgress("Unarchive")}
+	if err := u.mkdir(remoteDir); err != nil {
+		return err
+	}
+
+	switch format {
+	case ArchiveTar:
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := u.extract(hdr.Name, hdr.FileInfo(), tr); err != nil {
+				return err
+			}
+		}
+
+	case ArchiveZip:
+		src, cleanup, err := spool(r, unarchiveSpoolMemory)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		size, err := src.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(src.(io.ReaderAt), size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = u.extract(f.Name, f.FileInfo(), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("ftp: unknown archive format")
+}
+
+// unarchiver creates the entries of an archive under root.
+type unarchiver struct {
+	c    *ServerConn
+	root string
+	// directories known to exist
+	dirs map[string]bool
+	p    *progress
+}
+
+// extract creates the directory or uploads the file of an archive entry.
+func (u *unarchiver) extract(name string, info os.FileInfo, r io.Reader) error {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return nil
+	}
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return fmt.Errorf("ftp: archive entry %s outside of the directory", name)
+		}
+	}
+	remote := path.Join(u.root, clean)
+
+	switch {
+	case info.IsDir():
+		if err := u.mkdir(remote); err != nil {
+			return err
+		}
+		u.p.done(remote, 0)
+	case info.Mode().IsRegular():
+		if err := u.mkdir(path.Dir(remote)); err != nil {
+			return err
+		}
+		cr := &countingReader{r: r}
+		if err := u.c.Stor(remote, cr); err != nil {
+			return err
+		}
+		u.p.done(remote, cr.n)
+	}
+	return nil
+}
+
+// mkdir creates the remote directory and its parents, unless known to exist.
+func (u *unarchiver) mkdir(dir string) error {
+	if u.dirs[dir] || dir == "/" || dir == "." {
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := u.mkdir(parent); err != nil {
+			return err
+		}
+	}
+	if err := u.c.ensureDir(dir); err != nil {
+		return err
+	}
+	u.dirs[dir] = true
+	return nil
+}
@@ -0,0 +1,58 @@
+package ftp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnarchiveOutside(t *testing.T) {
+	u := &unarchiver{root: "/www", dirs: make(map[string]bool)}
+	for _, name := range []string{"../etc/passwd", "a/../../b", "..\\b"} {
+		err := u.extract(name, testEntry(name, "file"), strings.NewReader(""))
+		if err == nil {
+			t.Errorf("extract(%v) succeeded", name)
+		}
+	}
+}
+
+func TestArchiveRoot(t *testing.T) {
+	for _, root := range []string{".", "/", "/www/"} {
+		dir := path.Clean(root)
+		c, result := fileServer(map[string]string{
+			"MLST " + root: "250-Listing\r\n type=dir; " + root + "\r\n250 End",
+		}, map[string]string{
+			root:                  "type=file;size=5; a\r\ntype=dir; b\r\n",
+			path.Join(dir, "b"):   "type=file;size=2; c\r\n",
+			path.Join(dir, "a"):   "hello",
+			path.Join(dir, "b/c"): "hi",
+		})
+
+		var buf bytes.Buffer
+		if err := c.Archive(root, &buf, ArchiveTar); err != nil {
+			t.Fatalf("Archive(%q) = %v", root, err)
+		}
+		c.Close()
+		<-result
+
+		var names []string
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if want := []string{"a", "b/", "b/c"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("Archive(%q) wrote %q, want %q", root, names, want)
+		}
+	}
+}
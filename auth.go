@@ -0,0 +1,173 @@
+package ftp
+
+import (
+	"errors"
+	"net/textproto"
+)
+
+// Authenticator authenticates a connection to a FTP server. Mechanisms which
+// are not built in, such as custom SITE AUTH schemes, can be plugged in by
+// implementing it, typically with AuthenticatorFunc. GSSAPI (the ADAT
+// exchange of RFC 2228) is not built in.
+type Authenticator interface {
+	Authenticate(c *ServerConn) error
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(c *ServerConn) error
+
+// Authenticate calls f(c).
+func (f AuthenticatorFunc) Authenticate(c *ServerConn) error {
+	return f(c)
+}
+
+// UserPass authenticates with a user name and a password.
+type UserPass struct {
+	User     string
+	Password string
+}
+
+// Authenticate implements the Authenticator interface.
+func (a UserPass) Authenticate(c *ServerConn) error {
+	return c.Login(a.User, a.Password)
+}
+
+// Anonymous authenticates as the anonymous user, sending Email (or
+// "anonymous" if empty) as the password as is customary.
+type Anonymous struct {
+	Email string
+}
+
+// Authenticate implements the Authenticator interface.
+func (a Anonymous) Authenticate(c *ServerConn) error {
+	password := a.Email
+	if password == "" {
+		password = "anonymous"
+	}
+	return c.Login("anonymous", password)
+}
+
+// ChallengeResponse authenticates with a multi-step login, see
+// ServerConn.LoginChallenge.
+type ChallengeResponse struct {
+	User    string
+	Respond func(code int, message string) (string, error)
+}
+
+// Authenticate implements the Authenticator interface.
+func (a ChallengeResponse) Authenticate(c *ServerConn) error {
+	return c.LoginChallenge(a.User, a.Respond)
+}
+
+// ClientCertificate authenticates with the TLS client certificate presented
+// when the control connection was secured (see Dialer.ClientCertificates):
+// only USER is sent, which the server accepts with 232, or 230, without
+// asking for a password.
+type ClientCertificate struct {
+	User string
+}
+
+// Authenticate implements the Authenticator interface.
+func (a ClientCertificate) Authenticate(c *ServerConn) error {
+	code, message, err := c.cmd(-1, "USER %s", a.User)
+	if err != nil {
+		return opError("USER", "", err)
+	}
+	// 232 is the RFC 2228 reply for a user authorized by the security
+	// data exchange
+	if code != StatusLoggedIn && code != StatusLogoutAck {
+		return opError("USER", "", &textproto.Error{Code: code, Msg: message})
+	}
+
+	// Switch to binary mode
+	c.cmdMu.Lock()
+	c.transferType = ""
+	c.cmdMu.Unlock()
+	return opError("TYPE", "", c.setType("I"))
+}
+
+// ProxyScheme is the login sequence expected by a FTP application proxy, which
+// relays the session to the server selected during the login.
+type ProxyScheme int
+
+const (
+	// USER user@host, PASS password
+	ProxyUserAtHost ProxyScheme = iota
+	// USER proxyuser, PASS proxypassword, then USER user@host, PASS password
+	ProxyLoginUserAtHost
+	// USER proxyuser, PASS proxypassword, SITE host, then USER user,
+	// PASS password
+	ProxySite
+	// USER proxyuser, PASS proxypassword, OPEN host, then USER user,
+	// PASS password
+	ProxyOpen
+)
+
+// ProxyLogin authenticates through a FTP application proxy with the selected
+// scheme. Host is the server to connect to, as expected by the proxy (e.g.
+// "host" or "host:port"), and User and Password are the credentials on that
+// server. The proxy login is skipped if ProxyUser is empty, as some proxies
+// do not require it for ProxySite and ProxyOpen.
+type ProxyLogin struct {
+	Scheme        ProxyScheme
+	Host          string
+	User          string
+	Password      string
+	ProxyUser     string
+	ProxyPassword string
+}
+
+// Authenticate implements the Authenticator interface. The features of the
+// server are queried again once logged in, those known until then being the
+// proxy's.
+func (a ProxyLogin) Authenticate(c *ServerConn) error {
+	if a.Scheme != ProxyUserAtHost && a.ProxyUser != "" {
+		if err := c.login(a.ProxyUser, passwordOnly(a.ProxyPassword)); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch a.Scheme {
+	case ProxyUserAtHost, ProxyLoginUserAtHost:
+		err = c.Login(a.User+"@"+a.Host, a.Password)
+	case ProxySite, ProxyOpen:
+		command := "SITE"
+		if a.Scheme == ProxyOpen {
+			command = "OPEN"
+		}
+		if _, _, err = c.cmd(2, "%s %s", command, a.Host); err == nil {
+			err = c.Login(a.User, a.Password)
+		}
+	default:
+		err = errors.New("ftp: unknown proxy scheme")
+	}
+	if err != nil {
+		return err
+	}
+	return c.refreshFeatures()
+}
+
+// Authenticate authenticates the client with the specified mechanism.
+func (c *ServerConn) Authenticate(a Authenticator) error {
+	if err := a.Authenticate(c); err != nil {
+		return err
+	}
+	c.session.auth = a
+	return nil
+}
+
+// ConnectAuth connects to the specified ftp server address like Connect and
+// authenticates with the specified mechanism.
+func ConnectAuth(addr string, a Authenticator) (*ServerConn, error) {
+	c, err := Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Authenticate(a); err != nil {
+		c.Quit()
+		return nil, err
+	}
+	return c, nil
+}
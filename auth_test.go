@@ -0,0 +1,86 @@
+package ftp
+
+import (
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProxyLogin(t *testing.T) {
+	replies := map[string]string{
+		"USER": "331 password required",
+		"PASS": "230 logged in",
+		"SITE": "220 connected",
+		"TYPE": "200 ok",
+		"FEAT": "502 not implemented",
+	}
+	client, server := net.Pipe()
+	commands := make(chan string, 16)
+	go func() {
+		tc := textproto.NewConn(server)
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				close(commands)
+				return
+			}
+			commands <- line
+			tc.PrintfLine("%s", replies[strings.Fields(line)[0]])
+		}
+	}()
+
+	c := &ServerConn{limit: &limitReader{}, features: make(map[string]string)}
+	c.setControlConn(client)
+	err := c.Authenticate(ProxyLogin{
+		Scheme:        ProxySite,
+		Host:          "ftp.example.com",
+		User:          "user",
+		Password:      "secret",
+		ProxyUser:     "proxy",
+		ProxyPassword: "proxysecret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	var got []string
+	for cmd := range commands {
+		got = append(got, cmd)
+	}
+	want := []string{"USER proxy", "PASS proxysecret", "SITE ftp.example.com", "USER user", "PASS secret", "TYPE I", "FEAT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	for _, test := range []struct {
+		user string
+		ok   bool
+	}{
+		{"232 User logged in, authorized by security data exchange", true},
+		{"331 password required", false},
+	} {
+		c, commands := scriptedConn(map[string]string{"USER": test.user, "TYPE": "200 ok"})
+		err := c.Authenticate(ClientCertificate{User: "user"})
+		if (err == nil) != test.ok {
+			t.Errorf("Authenticate() after %q = %v", test.user, err)
+		}
+		c.Close()
+
+		var got []string
+		for cmd := range commands {
+			got = append(got, cmd)
+		}
+		want := []string{"USER user"}
+		if test.ok {
+			want = append(want, "TYPE I")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("commands = %q, want %q", got, want)
+		}
+	}
+}
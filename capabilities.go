@@ -0,0 +1,79 @@
+package ftp
+
+import "strings"
+
+// Capabilities summarizes what the server supports, as far as the client can
+// tell from the FEAT and SYST replies.
+type Capabilities struct {
+	// SYST reply and name of the detected ServerProfile, if any
+	System  string
+	Profile string
+
+	TLS        bool // AUTH TLS
+	MLSD       bool // MLST and MLSD
+	MDTM       bool // MDTM, reading modification times
+	MFMT       bool // MFMT, setting modification times
+	MFCT       bool // MFCT, setting creation times
+	Size       bool // SIZE
+	RestStream bool // REST STREAM, resuming STOR and RETR
+	UTF8       bool // UTF-8 path names
+	EPSV       bool // EPSV, passive mode for IPv6
+	ModeZ      bool // MODE Z, compressed transfers
+	// HASH algorithms, the selected one first
+	Hash []string
+
+	// format of the listings returned by ListEntries: "mlsd", "unix" for
+	// ls style LIST lines, or "" if the format of LIST is unknown
+	ListFormat string
+}
+
+// Capabilities returns the capabilities of the server. It does not issue any
+// command, the features and system are those known since the connection or
+// the last REIN.
+func (c *ServerConn) Capabilities() Capabilities {
+	caps := Capabilities{
+		System:  c.system,
+		Profile: c.profile,
+		Hash:    c.HashAlgorithms(),
+	}
+
+	has := func(feature string) bool {
+		_, ok := c.feature(feature)
+		return ok
+	}
+	param := func(feature string) string {
+		desc, _ := c.feature(feature)
+		return desc
+	}
+	caps.TLS = hasParam(param("AUTH"), "TLS")
+	caps.MLSD = has("MLST")
+	caps.MDTM = has("MDTM")
+	caps.MFMT = has("MFMT")
+	caps.MFCT = has("MFCT")
+	caps.Size = has("SIZE")
+	caps.RestStream = param("REST") == "STREAM"
+	caps.UTF8 = has("UTF8")
+	caps.EPSV = has("EPSV")
+	caps.ModeZ = hasParam(param("MODE"), "Z")
+
+	switch {
+	case caps.MLSD:
+		caps.ListFormat = "mlsd"
+	case strings.HasPrefix(strings.ToUpper(c.system), "UNIX"):
+		caps.ListFormat = "unix"
+	}
+	return caps
+}
+
+// hasParam reports whether the description of a feature lists param, the
+// parameters being separated by semicolons or spaces.
+func hasParam(desc, param string) bool {
+	for _, p := range strings.FieldsFunc(desc, func(r rune) bool {
+		return r == ';' || r == ' '
+	}) {
+		if strings.EqualFold(p, param) {
+			return true
+		}
+	}
+	return false
+}
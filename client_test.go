@@ -2,8 +2,16 @@ package ftp
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -111,6 +119,388 @@ func TestConn(t *testing.T) {
 	}
 }
 
+func TestSanitizeArgs(t *testing.T) {
+	_, err := sanitizeArgs([]interface{}{"file\r\nDELE other"})
+	if err != ErrInvalidArgument {
+		t.Errorf("sanitizeArgs() with CRLF returned err = %v, want %v", err, ErrInvalidArgument)
+	}
+
+	args, err := sanitizeArgs([]interface{}{"a\xffb", 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "a\xff\xffb" || args[1] != 42 {
+		t.Errorf("sanitizeArgs() = %q, want IAC doubled", args)
+	}
+
+	args, err = sanitizeArgs([]interface{}{"new\nline"})
+	if err != nil || args[0] != "new\x00line" {
+		t.Errorf("sanitizeArgs() = %q, %v, want LF sent as NUL", args, err)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		args   []interface{}
+		err    error
+	}{
+		{"SITE CHMOD %s %s", []interface{}{"644", "new\nline"}, nil},
+		{"SITE CHMOD %s %s", []interface{}{"644", "file\r\nDELE other"}, ErrInvalidArgument},
+		{"SITE CHMOD 644 file\r\nDELE other", nil, ErrInvalidArgument},
+		{"SITE %c", []interface{}{'\n'}, ErrInvalidArgument},
+	} {
+		if err := checkCommand(test.format, test.args); err != test.err {
+			t.Errorf("checkCommand(%q, %q) = %v, want %v", test.format, test.args, err, test.err)
+		}
+	}
+}
+
+func TestResponseLimits(t *testing.T) {
+	l := &limitReader{r: strings.NewReader(strings.Repeat("211-x\r\n", 100))}
+	l.reset(64)
+	_, err := ioutil.ReadAll(l)
+	if err != ErrResponseTooLarge {
+		t.Errorf("limitReader returned err = %v, want %v", err, ErrResponseTooLarge)
+	}
+
+	c := &ServerConn{MaxListLineSize: 16}
+	scanner := c.newListScanner(strings.NewReader(strings.Repeat("a", 32) + "\n"))
+	for scanner.Scan() {
+	}
+	if err := listScanErr(scanner); err != ErrResponseTooLarge {
+		t.Errorf("listScanErr() = %v, want %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c := &ServerConn{HistorySize: 2}
+	c.recordCommand("USER %s", "anonymous").reply(StatusUserOK)
+	c.recordCommand("PASS %s", "secret").reply(StatusLoggedIn)
+	c.recordCommand("NOOP").reply(StatusCommandOK)
+
+	h := c.History()
+	if len(h) != 2 {
+		t.Fatalf("History() returned %d entries, want 2", len(h))
+	}
+	if h[0].Command != "PASS ****" || h[0].Code != StatusLoggedIn {
+		t.Errorf("History()[0] = %v %v, want masked PASS", h[0].Command, h[0].Code)
+	}
+	if h[1].Command != "NOOP" {
+		t.Errorf("History()[1] = %v, want NOOP", h[1].Command)
+	}
+}
+
+func TestOpError(t *testing.T) {
+	reply := &textproto.Error{Code: StatusFileUnavailable, Msg: "No such file"}
+	err := opError("RETR", "/missing", opError("SIZE", "/missing", reply))
+
+	var oe *OpError
+	if !errors.As(err, &oe) || oe.Op != "SIZE" || oe.Path != "/missing" {
+		t.Fatalf("opError() = %v, want the SIZE error unchanged", err)
+	}
+	if r, ok := oe.Reply(); !ok || r.Code != StatusFileUnavailable {
+		t.Errorf("OpError.Reply() = %v, %v", r, ok)
+	}
+	if !isNotFound(err) {
+		t.Errorf("isNotFound(%v) = false", err)
+	}
+	if opError("RETR", "/file", nil) != nil {
+		t.Error("opError() wrapped a nil error")
+	}
+	if err := opError("PWD", "", reply); err.Error() != `ftp: PWD: 550 "No such file"` {
+		t.Errorf("opError() without path = %q", err)
+	}
+}
+
+func TestOpErrorWithoutPath(t *testing.T) {
+	c, _ := scriptedConn(map[string]string{"USER": "331 password", "PASS": "530 denied"})
+	defer c.Close()
+	for _, test := range []struct {
+		op string
+		fn func() error
+	}{
+		{"NOOP", c.NoOp},
+		{"REIN", c.Logout},
+		{"HOST", func() error { return c.Host("example.com") }},
+		{"SYST", func() error { _, err := c.System(); return err }},
+		{"PASS", func() error { return c.Login("user", "secret") }},
+		{"OPTS", func() error { return c.SetHashAlgorithm("SHA-256") }},
+		{"OPTS", func() error { return c.SetMListFacts("size") }},
+		{"AUTH", func() error { return c.AuthTLS(nil) }},
+		{"PROT", func() error { return c.SetDataProtection(true) }},
+		{"SSCN", func() error { return c.SetSSCN(true) }},
+	} {
+		var oe *OpError
+		if err := test.fn(); !errors.As(err, &oe) || oe.Op != test.op || oe.Path != "" {
+			t.Errorf("%s: err = %#v, want an *OpError without path", test.op, err)
+		}
+	}
+}
+
+func TestForceClose(t *testing.T) {
+	control, _ := net.Pipe()
+	c := &ServerConn{conn: textproto.NewConn(control)}
+	data, _ := net.Pipe()
+	conn := c.trackDataConn(data)
+
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	c.Close()
+	if err := <-done; err == nil {
+		t.Error("Read on a data connection succeeded after Close")
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	control, server := net.Pipe()
+	go ioutil.ReadAll(server) // never replies
+	c := &ServerConn{limit: &limitReader{}}
+	c.setControlConn(control)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.NoOpContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("NoOpContext() returned err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestControlTimeout(t *testing.T) {
+	control, server := net.Pipe()
+	go ioutil.ReadAll(server) // never replies
+	c := &ServerConn{limit: &limitReader{}, ControlTimeout: 10 * time.Millisecond}
+	c.setControlConn(control)
+
+	var netErr net.Error
+	if err := c.NoOp(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("NoOp() returned err = %v, want a timeout", err)
+	}
+}
+
+func TestPasv(t *testing.T) {
+	control, server := net.Pipe()
+	go func() {
+		tc := textproto.NewConn(server)
+		tc.ReadLine()
+		tc.PrintfLine("227 Entering Passive Mode (192,0,2,7,4,1).")
+	}()
+	c := &ServerConn{limit: &limitReader{}}
+	c.setControlConn(control)
+	defer c.Close()
+
+	host, port, err := c.pasv()
+	if err != nil || host != "192.0.2.7" || port != 1025 {
+		t.Errorf("pasv() = %v, %v, %v, want 192.0.2.7, 1025", host, port, err)
+	}
+}
+
+func TestCheckPASVAddress(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	c := &ServerConn{
+		netConn:       &addrConn{remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 21}},
+		PASVAllowlist: []*net.IPNet{allowed},
+	}
+	for ip, want := range map[string]error{
+		"192.0.2.1": nil,
+		"10.1.2.3":  nil,
+		"127.0.0.1": ErrPASVAddress,
+		"192.0.2.2": ErrPASVAddress,
+	} {
+		if err := c.checkPASVAddress(net.ParseIP(ip)); err != want {
+			t.Errorf("checkPASVAddress(%v) = %v, want %v", ip, err, want)
+		}
+	}
+}
+
+// scriptedConn returns a ServerConn talking to a server which answers each
+// command with the reply for the whole command line in replies, or else for
+// its verb, and sends the commands to the returned channel.
+// hangUp is a scripted reply which closes the connection instead.
+const hangUp = "<hang up>"
+
+// serveScript sends greeting, if any, on conn and answers each command with
+// the reply for its line or else for its verb, or with 502. The commands
+// received are sent to commands, if not nil.
+func serveScript(conn net.Conn, greeting string, replies map[string]string, commands chan<- string) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	if greeting != "" {
+		tc.PrintfLine("%s", greeting)
+	}
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		if commands != nil {
+			commands <- line
+		}
+		reply, ok := replies[line]
+		if !ok {
+			reply, ok = replies[strings.Fields(line)[0]]
+		}
+		if !ok {
+			reply = "502 not implemented"
+		}
+		if reply == hangUp {
+			return
+		}
+		tc.PrintfLine("%s", reply)
+	}
+}
+
+// scriptedConn returns a connection to a server answering with replies, as
+// serveScript, and the channel of the commands it received, closed with the
+// connection.
+func scriptedConn(replies map[string]string) (*ServerConn, <-chan string) {
+	control, server := net.Pipe()
+	commands := make(chan string, 64)
+	go func() {
+		serveScript(server, "", replies, commands)
+		close(commands)
+	}()
+
+	c := &ServerConn{limit: &limitReader{}, features: make(map[string]string)}
+	c.setControlConn(control)
+	return c, commands
+}
+
+func TestEPSVAll(t *testing.T) {
+	c, commands := scriptedConn(map[string]string{
+		"EPSV ALL": "200 EPSV ALL ok",
+		"EPSV":     "229 Entering Extended Passive Mode (|||6446|)",
+	})
+	c.features["EPSV"] = ""
+	c.UseEPSVAll = true
+
+	for i := 0; i < 2; i++ {
+		if host, port, err := c.passive(); err != nil || host != "" || port != 6446 {
+			t.Errorf("passive() = %v, %v, %v, want EPSV port 6446", host, port, err)
+		}
+	}
+	c.Close()
+
+	var got []string
+	for cmd := range commands {
+		got = append(got, cmd)
+	}
+	if want := []string{"EPSV ALL", "EPSV", "EPSV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestPassiveFallback(t *testing.T) {
+	c, commands := scriptedConn(map[string]string{
+		"PASV": "227 Entering Passive Mode (192,0,2,7,4,1).",
+	})
+	for i := 0; i < 2; i++ {
+		if _, port, err := c.passive(); err != nil || port != 1025 {
+			t.Errorf("passive() = %v, %v, want PASV port 1025", port, err)
+		}
+	}
+	c.PassiveMode = PassiveEPSVOnly
+	if _, _, err := c.passive(); err == nil {
+		t.Error("passive() in PassiveEPSVOnly mode succeeded after EPSV failed")
+	}
+	c.Close()
+
+	var got []string
+	for cmd := range commands {
+		got = append(got, cmd)
+	}
+	if want := []string{"EPSV", "PASV", "PASV", "EPSV"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestPassiveIPv6(t *testing.T) {
+	c, _ := scriptedConn(map[string]string{
+		"EPSV": "229 Entering Extended Passive Mode (|||6446|)",
+	})
+	defer c.Close()
+	control := c.netConn
+	c.netConn = &addrConn{Conn: control, remote: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 21}}
+	c.DisableEPSV = true
+
+	if _, port, err := c.passive(); err != nil || port != 6446 {
+		t.Errorf("passive() = %v, %v, want EPSV port 6446 over IPv6", port, err)
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	c, _ := scriptedConn(map[string]string{"NOOP": "200 ok"})
+	defer c.Close()
+	c.HistorySize = 4
+
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			errs <- c.NoOp()
+		}()
+		go c.History()
+		go c.LastReply()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent NoOp() returned err = %v", err)
+		}
+	}
+
+	c.transferring = true
+	if err := c.NoOp(); !errors.Is(err, ErrTransferInProgress) {
+		t.Errorf("NoOp() during a transfer returned err = %v, want %v", err, ErrTransferInProgress)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestStoreReadError(t *testing.T) {
+	c, commands := scriptedConn(map[string]string{
+		"TYPE": "200 ok",
+		"EPSV": "229 Entering Extended Passive Mode (|||6446|)",
+		"STOR": "150 ok\r\n226 done",
+		"NOOP": "200 ok",
+	})
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go io.Copy(ioutil.Discard, server)
+		return client, nil
+	}
+
+	if err := c.Stor("file", failingReader{}); err == nil || !strings.Contains(err.Error(), "read failed") {
+		t.Errorf("Stor() = %v, want the read error", err)
+	}
+	if err := c.NoOp(); err != nil {
+		t.Errorf("NoOp() after a failed Stor = %v", err)
+	}
+	c.Close()
+
+	var got []string
+	for cmd := range commands {
+		got = append(got, cmd)
+	}
+	if want := []string{"TYPE I", "EPSV", "STOR file", "NOOP"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestDebugOutput(t *testing.T) {
+	var out bytes.Buffer
+	d := &debugWriter{&out}
+	d.Write([]byte("USER anonymous\r\n"))
+	d.Write([]byte("PASS secret\r\n"))
+	if want := "USER anonymous\r\nPASS ****\r\n"; out.String() != want {
+		t.Errorf("debug output = %q, want %q", out.String(), want)
+	}
+}
+
 // ftp.mozilla.org uses multiline 220 response
 func TestConn2(t *testing.T) {
 	c, err := Connect("ftp.mozilla.org:21")
@@ -150,3 +540,47 @@ func TestConnIPv6(t *testing.T) {
 
 	c.Quit()
 }
+
+func TestLinkTarget(t *testing.T) {
+	listing := "lrwxrwxrwx 1 user group 4 Jan 01 2020 link -> dest\r\n"
+	for _, test := range []struct {
+		name   string
+		target string
+		isLink bool
+	}{
+		{"dir/link", "dir/dest", true},
+		// a directory holding a single link
+		{"dir", "", false},
+	} {
+		c, _ := fileServer(nil, map[string]string{test.name: listing})
+		target, isLink, err := c.linkTarget(test.name)
+		c.Close()
+		if err != nil || target != test.target || isLink != test.isLink {
+			t.Errorf("linkTarget(%q) = %q, %v, %v, want %q, %v", test.name, target, isLink, err, test.target, test.isLink)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	c, _ := scriptedConn(map[string]string{"SITE": "200 Maximum idle time set to 600 seconds"})
+	defer c.Close()
+
+	if err := c.SetIdleTimeout(-time.Second); err == nil {
+		t.Error("SetIdleTimeout() with a negative duration succeeded")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			c.IdleTimeout()
+		}
+	}()
+	if err := c.SetIdleTimeout(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if d := c.IdleTimeout(); d != 10*time.Minute {
+		t.Errorf("IdleTimeout() = %v, want the granted 10m", d)
+	}
+}
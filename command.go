@@ -0,0 +1,68 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Command issues an arbitrary FTP command, so that extensions this package
+// does not implement, such as SITE commands, can be used. The arguments are
+// checked and escaped like those of the other commands, and a command line
+// holding CR or LF, e.g. through a non-constant format, is rejected with
+// ErrInvalidArgument.
+//
+// The reply must match expected, which is a full code or its first digits
+// (e.g. 2 accepts any positive completion reply); a negative value accepts
+// any reply. A mismatching reply is returned as a *textproto.Error.
+func (c *ServerConn) Command(expected int, format string, args ...interface{}) (Reply, error) {
+	if err := checkCommand(format, args); err != nil {
+		return Reply{}, opError(commandVerb(format), "", err)
+	}
+	code, msg, err := c.cmd(expected, format, args...)
+	return Reply{code, msg}, opError(commandVerb(format), "", err)
+}
+
+// DataCommand issues an arbitrary FTP command which transfers data, such as
+// a proprietary listing or THMB. fn is called with the data connection, to
+// read from or write to it, and the connection is closed once it returns.
+// DataCommand then waits for the end of the transfer. The command line is
+// checked like that of Command.
+func (c *ServerConn) DataCommand(fn func(conn net.Conn) error, format string, args ...interface{}) error {
+	if err := checkCommand(format, args); err != nil {
+		return opError(commandVerb(format), "", err)
+	}
+	conn, err := c.cmdDataConnFrom(0, format, args...)
+	if err != nil {
+		return opError(commandVerb(format), "", err)
+	}
+
+	err = fn(conn)
+	conn.Close()
+	if err2 := c.readTransferComplete(); err == nil {
+		err = err2
+	}
+	return opError(commandVerb(format), "", err)
+}
+
+// commandVerb returns the command of a Command format, as the Op of its
+// errors.
+func commandVerb(format string) string {
+	if i := strings.IndexByte(format, ' '); i >= 0 {
+		format = format[:i]
+	}
+	return strings.ToUpper(format)
+}
+
+// checkCommand returns ErrInvalidArgument if the command line formatted from
+// format and args would hold CR or LF, and thus inject other commands.
+func checkCommand(format string, args []interface{}) error {
+	args, err := sanitizeArgs(args)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(fmt.Sprintf(format, args...), "\r\n") {
+		return ErrInvalidArgument
+	}
+	return nil
+}
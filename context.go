@@ -0,0 +1,216 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// The Context variants of the methods abort the operation when the context
+// is done, by closing the connection: a server which does not reply cannot
+// block the caller forever. The connection is not usable anymore once an
+// operation has been aborted, and the error returned is the one of the
+// context.
+
+// ConnectContext connects like Connect, aborting when ctx is done.
+func (d *Dialer) ConnectContext(ctx context.Context, addr string) (*ServerConn, error) {
+	return d.connect(ctx, addr)
+}
+
+// ConnectContext connects like Connect, aborting when ctx is done.
+func ConnectContext(ctx context.Context, addr string) (*ServerConn, error) {
+	var d Dialer
+	return d.connect(ctx, addr)
+}
+
+// closerFunc adapts a function to the io.Closer interface.
+type closerFunc func() error
+
+// Close calls f().
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// withContext runs fn, closing the control and data connections if ctx is
+// done before it returns.
+func (c *ServerConn) withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := closeOnDone(ctx, closerFunc(c.Close))
+	err := fn()
+	stop()
+	return contextErr(ctx, err)
+}
+
+// LoginContext logs in like Login, aborting when ctx is done.
+func (c *ServerConn) LoginContext(ctx context.Context, user, password string) error {
+	return c.withContext(ctx, func() error {
+		return c.Login(user, password)
+	})
+}
+
+// AuthenticateContext authenticates like Authenticate, aborting when ctx is
+// done.
+func (c *ServerConn) AuthenticateContext(ctx context.Context, a Authenticator) error {
+	return c.withContext(ctx, func() error {
+		return c.Authenticate(a)
+	})
+}
+
+// ListContext lists the directory like List, aborting when ctx is done.
+func (c *ServerConn) ListContext(ctx context.Context, path string, opts ...ListOption) (entries []*Entry, err error) {
+	err = c.withContext(ctx, func() error {
+		entries, err = c.List(path, opts...)
+		return err
+	})
+	return entries, err
+}
+
+// MListContext lists the directory like MList, aborting when ctx is done.
+func (c *ServerConn) MListContext(ctx context.Context, path string, opts ...ListOption) (entries []EntryEx, err error) {
+	err = c.withContext(ctx, func() error {
+		entries, err = c.MList(path, opts...)
+		return err
+	})
+	return entries, err
+}
+
+// NameListContext lists the directory like NameList, aborting when ctx is
+// done.
+func (c *ServerConn) NameListContext(ctx context.Context, path string) (entries []string, err error) {
+	err = c.withContext(ctx, func() error {
+		entries, err = c.NameList(path)
+		return err
+	})
+	return entries, err
+}
+
+// RetrContext fetches the file like Retr. ctx bounds the whole transfer,
+// until the returned ReadCloser is closed.
+func (c *ServerConn) RetrContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.RetrFromContext(ctx, path, 0)
+}
+
+// RetrFromContext fetches the file from offset like RetrFrom. ctx bounds the
+// whole transfer, until the returned ReadCloser is closed.
+func (c *ServerConn) RetrFromContext(ctx context.Context, path string, offset uint64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, closerFunc(c.Close))
+	r, err := c.RetrFrom(path, offset)
+	if err != nil {
+		stop()
+		return nil, contextErr(ctx, err)
+	}
+	return &contextResponse{r, ctx, stop}, nil
+}
+
+// contextResponse is a transfer bounded by a context.
+type contextResponse struct {
+	r    io.ReadCloser
+	ctx  context.Context
+	stop func()
+}
+
+// Read implements the io.Reader interface.
+func (r *contextResponse) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if err != io.EOF {
+		err = contextErr(r.ctx, err)
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (r *contextResponse) Close() error {
+	err := r.r.Close()
+	r.stop()
+	return contextErr(r.ctx, err)
+}
+
+// StorContext stores the file like Stor, aborting when ctx is done.
+func (c *ServerConn) StorContext(ctx context.Context, path string, r io.Reader) error {
+	return c.withContext(ctx, func() error {
+		return c.Stor(path, r)
+	})
+}
+
+// StorFromContext stores the file from offset like StorFrom, aborting when
+// ctx is done.
+func (c *ServerConn) StorFromContext(ctx context.Context, path string, r io.Reader, offset uint64) error {
+	return c.withContext(ctx, func() error {
+		return c.StorFrom(path, r, offset)
+	})
+}
+
+// AppendContext appends to the file like Append, aborting when ctx is done.
+func (c *ServerConn) AppendContext(ctx context.Context, path string, r io.Reader) error {
+	return c.withContext(ctx, func() error {
+		return c.Append(path, r)
+	})
+}
+
+// FileSizeContext returns the size of the file like FileSize, aborting when
+// ctx is done.
+func (c *ServerConn) FileSizeContext(ctx context.Context, path string) (size int64, err error) {
+	err = c.withContext(ctx, func() error {
+		size, err = c.FileSize(path)
+		return err
+	})
+	return size, err
+}
+
+// ModTimeContext returns the modification time of the file like ModTime,
+// aborting when ctx is done.
+func (c *ServerConn) ModTimeContext(ctx context.Context, path string) (t time.Time, err error) {
+	err = c.withContext(ctx, func() error {
+		t, err = c.ModTime(path)
+		return err
+	})
+	return t, err
+}
+
+// ChangeDirContext changes the directory like ChangeDir, aborting when ctx
+// is done.
+func (c *ServerConn) ChangeDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.ChangeDir(path)
+	})
+}
+
+// RenameContext renames the file like Rename, aborting when ctx is done.
+func (c *ServerConn) RenameContext(ctx context.Context, from, to string) error {
+	return c.withContext(ctx, func() error {
+		return c.Rename(from, to)
+	})
+}
+
+// DeleteContext deletes the file like Delete, aborting when ctx is done.
+func (c *ServerConn) DeleteContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.Delete(path)
+	})
+}
+
+// MakeDirContext creates the directory like MakeDir, aborting when ctx is
+// done.
+func (c *ServerConn) MakeDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.MakeDir(path)
+	})
+}
+
+// RemoveDirContext removes the directory like RemoveDir, aborting when ctx
+// is done.
+func (c *ServerConn) RemoveDirContext(ctx context.Context, path string) error {
+	return c.withContext(ctx, func() error {
+		return c.RemoveDir(path)
+	})
+}
+
+// NoOpContext sends NOOP like NoOp, aborting when ctx is done.
+func (c *ServerConn) NoOpContext(ctx context.Context) error {
+	return c.withContext(ctx, c.NoOp)
+}
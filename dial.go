@@ -0,0 +1,307 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialer contains options for connecting to a FTP server. The zero value is
+// equivalent to calling Connect.
+type Dialer struct {
+	// Resolver is used to look up the host names of the control and data
+	// connections, e.g. for split-horizon DNS or DNS over HTTPS. If nil,
+	// net.DefaultResolver is used.
+	Resolver *net.Resolver
+	// DialContext, if not nil, is used to open the control and data
+	// connections instead of dialing TCP directly, e.g. to go through a
+	// SOCKS proxy, or the DialContext method of a net.Dialer bound to an
+	// interface. It resolves the host names itself, Resolver is not used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// LocalAddr is the local address the control and data connections are
+	// bound to, e.g. the IP of a network interface, with a zero port. Only
+	// the addresses of the same family are tried. It is not used with
+	// DialContext.
+	LocalAddr *net.TCPAddr
+	// SocketHook, if not nil, is called with each TCP connection once
+	// established, the control one and the data ones (data is true), to
+	// set socket options such as keep-alives, TCP_NODELAY or larger
+	// buffers for high-latency links.
+	SocketHook func(conn *net.TCPConn, data bool) error
+
+	// ExplicitTLS secures the control connection with AUTH TLS right after
+	// the greeting, as described in RFC 4217, and the data connections with
+	// PROT P. A non-nil TLSConfig implies it.
+	ExplicitTLS bool
+	// TLSConfig is the TLS configuration of the control and data
+	// connections; if nil, DefaultTLSConfig is used. If its ServerName is
+	// empty, the host of the address is used.
+	TLSConfig *tls.Config
+	// ClientCertificates are presented to the servers which request a
+	// client certificate during the TLS handshake. GetClientCertificate, if
+	// not nil, selects the certificate per host instead.
+	ClientCertificates   []tls.Certificate
+	GetClientCertificate func(host string) (*tls.Certificate, error)
+
+	// Timeout is the maximum time of each connection attempt, for the
+	// control and the data connections; zero means no timeout.
+	// ControlTimeout and DataTimeout set the fields of the same name of the
+	// ServerConn, ControlTimeout bounding the greeting and the TLS handshake
+	// as well.
+	Timeout        time.Duration
+	ControlTimeout time.Duration
+	DataTimeout    time.Duration
+	// AttemptTimeout is the maximum time ConnectFirst spends on each
+	// address, from the dial to the end of the session initialization;
+	// zero means no timeout.
+	AttemptTimeout time.Duration
+
+	// ProxyProtocol, if not zero, is the version (1 or 2) of the PROXY
+	// protocol header sent on the control and data connections right after
+	// they are established, for the load balancers which expect it to pass
+	// on the address of the client.
+	ProxyProtocol int
+
+	// DebugOutput, if not nil, receives a copy of the traffic of the control
+	// connection, the passwords being masked.
+	DebugOutput io.Writer
+}
+
+// tlsConfig returns the TLS configuration of the connections to host.
+func (d *Dialer) tlsConfig(host string) *tls.Config {
+	config := d.TLSConfig.Clone()
+	if config == nil {
+		config = DefaultTLSConfig()
+	}
+	if len(d.ClientCertificates) > 0 {
+		config.Certificates = append(config.Certificates, d.ClientCertificates...)
+	}
+	if d.GetClientCertificate != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return d.GetClientCertificate(host)
+		}
+	}
+	return config
+}
+
+// Connect initializes the connection to the specified ftp server address
+// using the options of the Dialer.
+//
+// It is generally followed by a call to Login() as most FTP commands require
+// an authenticated user.
+func (d *Dialer) Connect(addr string) (*ServerConn, error) {
+	return d.connect(context.Background(), addr)
+}
+
+// connect connects like Connect, aborting when ctx is done.
+func (d *Dialer) connect(ctx context.Context, addr string) (*ServerConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dctx := ctx
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		dctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	tconn, err := d.dialFunc()(dctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketHook(d.SocketHook, tconn, false); err != nil {
+		tconn.Close()
+		return nil, err
+	}
+	if d.ProxyProtocol != 0 {
+		if err := writeProxyHeader(tconn, d.ProxyProtocol); err != nil {
+			tconn.Close()
+			return nil, err
+		}
+	}
+	c, err := d.newConn(ctx, tconn, host)
+	if err != nil {
+		return nil, err
+	}
+	c.session.dialer, c.session.addr = *d, addr
+	return c, nil
+}
+
+// ConnectFirst tries to connect to the addresses in order, e.g. a primary
+// and a secondary server, and returns the first session initialized
+// successfully: the server must have sent its greeting and, if TLS is
+// requested, completed the handshake. If all attempts fail, the error of the
+// first one is returned.
+func (d *Dialer) ConnectFirst(addrs ...string) (*ServerConn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("ftp: no address to connect to")
+	}
+
+	var firstErr error
+	for _, addr := range addrs {
+		c, err := d.connectAttempt(addr)
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// connectAttempt connects to addr within AttemptTimeout.
+func (d *Dialer) connectAttempt(addr string) (*ServerConn, error) {
+	ctx := context.Background()
+	if d.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.AttemptTimeout)
+		defer cancel()
+	}
+	return d.connect(ctx, addr)
+}
+
+// NewConn initializes a FTP session over conn, an established connection to
+// the server such as a tunnel, using the options of the Dialer. host is the
+// name of the server, used to verify its TLS certificate and to open the
+// data connections; DialContext can route the latter through the same
+// transport.
+//
+// conn is closed if the session cannot be initialized.
+func (d *Dialer) NewConn(conn net.Conn, host string) (*ServerConn, error) {
+	return d.newConn(context.Background(), conn, host)
+}
+
+// NewConn initializes a FTP session over conn, an established connection to
+// the server host. See Dialer.NewConn.
+func NewConn(conn net.Conn, host string) (*ServerConn, error) {
+	var d Dialer
+	return d.NewConn(conn, host)
+}
+
+// newConn initializes the session like NewConn, aborting when ctx is done.
+func (d *Dialer) newConn(ctx context.Context, tconn net.Conn, host string) (*ServerConn, error) {
+	stop := closeOnDone(ctx, tconn)
+	defer stop()
+
+	// the data addresses are built with net.JoinHostPort
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+
+	c := &ServerConn{
+		host:            host,
+		features:        make(map[string]string),
+		dial:            d.dialFunc(),
+		limit:           &limitReader{},
+		debug:           d.DebugOutput,
+		MaxResponseSize: DefaultMaxResponseSize,
+		MaxListLineSize: DefaultMaxListLineSize,
+		dialTimeout:     d.Timeout,
+		ControlTimeout:  d.ControlTimeout,
+		DataTimeout:     d.DataTimeout,
+		proxyProtocol:   d.ProxyProtocol,
+		socketHook:      d.SocketHook,
+	}
+	c.setControlConn(tconn)
+
+	var err error
+	c.limit.reset(c.MaxResponseSize)
+	_, c.greeting, err = c.conn.ReadResponse(StatusReady)
+	if err != nil {
+		c.Quit()
+		return nil, contextErr(ctx, err)
+	}
+
+	if d.ExplicitTLS || d.TLSConfig != nil {
+		// the features are queried once secured
+		err = c.AuthTLS(d.tlsConfig(host))
+	} else {
+		err = c.refreshFeatures()
+	}
+	if err != nil {
+		c.Quit()
+		return nil, contextErr(ctx, err)
+	}
+
+	c.detectProfile()
+
+	return c, nil
+}
+
+// dialFunc returns the function opening the connections: DialContext, or
+// a net.Dialer with the Resolver and LocalAddr, which races the IPv6 and
+// IPv4 addresses of a host (RFC 8305).
+func (d *Dialer) dialFunc() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.DialContext != nil {
+		return d.DialContext
+	}
+	nd := &net.Dialer{Resolver: d.Resolver, FallbackDelay: connectionAttemptDelay}
+	if d.LocalAddr != nil {
+		// only the addresses of its family are tried
+		nd.LocalAddr = d.LocalAddr
+	}
+	return nd.DialContext
+}
+
+// applySocketHook calls hook, if not nil, with conn if it is a TCP
+// connection.
+func applySocketHook(hook func(conn *net.TCPConn, data bool) error, conn net.Conn, data bool) error {
+	if tc, ok := conn.(*net.TCPConn); ok && hook != nil {
+		return hook(tc, data)
+	}
+	return nil
+}
+
+// dialTimeout connects to the TCP address addr with dial, within timeout if
+// it is not zero.
+func dialTimeout(timeout time.Duration, dial func(ctx context.Context, network, addr string) (net.Conn, error), addr string) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return dial(ctx, "tcp", addr)
+}
+
+// connectionAttemptDelay is the delay before trying the other address
+// family, as recommended by RFC 8305.
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// closeOnDone closes conn when ctx is done, until stop is called, so that
+// blocking reads and writes are interrupted.
+func closeOnDone(ctx context.Context, conn io.Closer) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stopc := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopc:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopc) })
+	}
+}
+
+// contextErr returns the error of ctx if it is done, as it is the cause of
+// err, and err otherwise.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return ctxErr
+	}
+	return err
+}
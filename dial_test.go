@@ -0,0 +1,96 @@
+package ftp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialFunc(t *testing.T) {
+	var dialed string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		client, server := net.Pipe()
+		go serveScript(server, "220 ready", nil, nil)
+		return client, nil
+	}
+
+	c, err := Dial("ftp.example.invalid:21", DialWithDialFunc(dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if dialed != "ftp.example.invalid:21" {
+		t.Errorf("dialed %v, want the unresolved address", dialed)
+	}
+}
+
+func TestNewConn(t *testing.T) {
+	client, server := net.Pipe()
+	go serveScript(server, "220 ready", nil, nil)
+
+	c, err := NewConn(client, "ftp.example.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.host != "ftp.example.invalid" {
+		t.Errorf("host = %v, want ftp.example.invalid", c.host)
+	}
+
+	client, server = net.Pipe()
+	go serveScript(server, "220 ready", nil, nil)
+	c, err = NewConn(client, "[2001:db8::1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.host != "2001:db8::1" {
+		t.Errorf("host = %v, want the IPv6 literal without brackets", c.host)
+	}
+}
+
+func TestConnectFirst(t *testing.T) {
+	var dialed []string
+	d := Dialer{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		client, server := net.Pipe()
+		if addr == "primary:21" {
+			// accepts the connection, but never greets
+			go ioutil.ReadAll(server)
+		} else {
+			go serveScript(server, "220 ready", nil, nil)
+		}
+		return client, nil
+	}, AttemptTimeout: 10 * time.Millisecond}
+
+	c, err := d.ConnectFirst("primary:21", "secondary:21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.host != "secondary" || len(dialed) != 2 {
+		t.Errorf("connected to %v after dialing %v, want secondary", c.host, dialed)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	d := Dialer{LocalAddr: local}
+	conn, err := d.dialFunc()(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(local.IP) {
+		t.Errorf("connection bound to %v, want %v", ip, local.IP)
+	}
+}
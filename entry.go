@@ -0,0 +1,123 @@
+package ftp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mode returns the os.FileMode type bits of the entry type.
+func (t EntryType) mode() os.FileMode {
+	switch t {
+	case EntryTypeFolder:
+		return os.ModeDir
+	case EntryTypeLink:
+		return os.ModeSymlink
+	case EntryTypeSocket:
+		return os.ModeSocket
+	case EntryTypeFifo:
+		return os.ModeNamedPipe
+	case EntryTypeCharDevice:
+		return os.ModeDevice | os.ModeCharDevice
+	case EntryTypeBlockDevice:
+		return os.ModeDevice
+	}
+	return 0
+}
+
+// parseLsMode parses the permission bits of a "ls -l" style mode string such
+// as "drwxr-sr-x".
+func parseLsMode(s string) os.FileMode {
+	if len(s) < 10 {
+		return 0
+	}
+
+	var mode os.FileMode
+	for i, c := range s[1:10] {
+		bit := os.FileMode(1) << uint(8-i)
+		switch c {
+		case 'r', 'w', 'x':
+			mode |= bit
+		case 's', 't':
+			mode |= bit
+			fallthrough
+		case 'S', 'T':
+			switch i {
+			case 2:
+				mode |= os.ModeSetuid
+			case 5:
+				mode |= os.ModeSetgid
+			case 8:
+				mode |= os.ModeSticky
+			}
+		}
+	}
+	return mode
+}
+
+// Entry converts the MLSx entry to an Entry.
+func (e EntryEx) Entry() *Entry {
+	entry := &Entry{
+		Name:  e.name,
+		Type:  e.Type(),
+		Size:  uint64(e.Size()),
+		Time:  e.ModTime(),
+		Mode:  e.Mode(),
+		Owner: e.Facts["unix.owner"],
+		Group: e.Facts["unix.group"],
+		Facts: e.Facts,
+		Raw:   e.raw,
+	}
+	if entry.Owner == "" {
+		entry.Owner = e.Facts["unix.uid"]
+	}
+	if entry.Group == "" {
+		entry.Group = e.Facts["unix.gid"]
+	}
+	if perm, err := strconv.ParseUint(e.Facts["unix.mode"], 8, 32); err == nil {
+		entry.Mode = os.FileMode(perm)&os.ModePerm | entry.Type.mode()
+	}
+	// e.g. "OS.unix=slink:/usr/bin"
+	if eType := e.Facts["type"]; entry.Type == EntryTypeLink {
+		if i := strings.Index(eType, ":"); i != -1 {
+			entry.Target = eType[i+1:]
+		}
+	}
+	return entry
+}
+
+// ListEntries lists the specified directory with MLSD if the server supports
+// it, with LIST otherwise, and returns the entries in the same model.
+func (c *ServerConn) ListEntries(path string, opts ...ListOption) ([]*Entry, error) {
+	if _, mlstSupported := c.feature("MLST"); !mlstSupported {
+		return c.List(path, opts...)
+	}
+
+	mentries, err := c.MList(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*Entry, len(mentries))
+	for i, e := range mentries {
+		entries[i] = e.Entry()
+	}
+	return c.applyListOptions(path, entries, opts)
+}
+
+// FileInfo returns an os.FileInfo describing the entry.
+func (e *Entry) FileInfo() os.FileInfo {
+	return entryInfo{e}
+}
+
+// entryInfo implements the os.FileInfo interface for an Entry.
+type entryInfo struct {
+	e *Entry
+}
+
+func (fi entryInfo) Name() string       { return fi.e.Name }
+func (fi entryInfo) Size() int64        { return int64(fi.e.Size) }
+func (fi entryInfo) Mode() os.FileMode  { return fi.e.Mode }
+func (fi entryInfo) ModTime() time.Time { return fi.e.Time }
+func (fi entryInfo) IsDir() bool        { return fi.e.Type == EntryTypeFolder }
+func (fi entryInfo) Sys() interface{}   { return fi.e }
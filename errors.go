@@ -0,0 +1,59 @@
+package ftp
+
+import (
+	"errors"
+	"net/textproto"
+)
+
+// OpError is the error returned by the methods of ServerConn issuing FTP
+// commands. It records the FTP command and the path which caused it, empty
+// for the commands without a path.
+type OpError struct {
+	// FTP command, such as "RETR" or "MKD"
+	Op   string
+	Path string
+	// underlying error, a *textproto.Error for a negative reply
+	Err error
+}
+
+func (e *OpError) Error() string {
+	if e.Path == "" {
+		return "ftp: " + e.Op + ": " + e.Err.Error()
+	}
+	return "ftp: " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Reply returns the negative reply of the server which caused the error, or
+// false if the error was not caused by a reply.
+func (e *OpError) Reply() (Reply, bool) {
+	var te *textproto.Error
+	if !errors.As(e.Err, &te) {
+		return Reply{}, false
+	}
+	return Reply{te.Code, te.Msg}, true
+}
+
+// opError wraps err in an OpError for the specified command and path, unless
+// it is nil or already an OpError.
+func opError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var oe *OpError
+	if errors.As(err, &oe) {
+		return err
+	}
+	return &OpError{Op: op, Path: path, Err: err}
+}
+
+// replyError returns the negative reply err is or wraps, if any.
+func replyError(err error) (*textproto.Error, bool) {
+	var te *textproto.Error
+	ok := errors.As(err, &te)
+	return te, ok
+}
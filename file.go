@@ -0,0 +1,211 @@
+package ftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// File is a file on the remote FTP server opened by OpenFile. It approximates
+// *os.File on top of RETR, STOR and APPE: reads and writes are streamed over
+// a data connection opened at the current offset on first use, and Seek, Stat
+// and Close finish the pending transfer.
+//
+// As a ServerConn carries a single transfer at a time, no other command may
+// be issued on it while a File is reading or writing.
+type File struct {
+	c      *ServerConn
+	name   string
+	flag   int
+	offset int64
+
+	// pending download
+	r io.ReadCloser
+	// pending upload and its result
+	w    *io.PipeWriter
+	done chan error
+}
+
+// OpenFile opens the named file on the remote FTP server. flag is a
+// combination of os.O_RDONLY, os.O_WRONLY, os.O_APPEND, os.O_CREATE,
+// os.O_EXCL and os.O_TRUNC; os.O_RDWR is not supported as FTP can not read
+// and write a file over the same transfer.
+//
+// As FTP can not overwrite part of a file, writes require os.O_APPEND or
+// os.O_TRUNC: os.O_TRUNC truncates the file right away by storing it empty,
+// and writes are then issued as STOR (with REST after a Seek), which
+// truncates the file at the offset written to.
+func (c *ServerConn) OpenFile(name string, flag int) (*File, error) {
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY, os.O_WRONLY:
+	default:
+		return nil, errors.New("ftp: O_RDWR is not supported")
+	}
+	if flag&os.O_WRONLY != 0 && flag&(os.O_APPEND|os.O_TRUNC) == 0 {
+		return nil, errors.New("ftp: writes require O_APPEND or O_TRUNC")
+	}
+
+	if flag&os.O_WRONLY != 0 && flag&(os.O_CREATE|os.O_EXCL) != os.O_CREATE {
+		exists, err := c.Exists(name)
+		if err != nil {
+			return nil, err
+		}
+		if flag&os.O_CREATE == 0 && !exists {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if flag&os.O_CREATE != 0 && exists {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+	}
+
+	if flag&os.O_WRONLY != 0 && flag&os.O_TRUNC != 0 {
+		if err := c.StorFrom(name, strings.NewReader(""), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return &File{c: c, name: name, flag: flag}, nil
+}
+
+// Name returns the name of the file as presented to OpenFile.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Read implements the io.Reader interface, issuing RETR (with REST if the
+// offset is not zero) on first use.
+func (f *File) Read(buf []byte) (int, error) {
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, opError("RETR", f.name, errors.New("ftp: file not opened for reading"))
+	}
+
+	if f.r == nil {
+		path := f.c.toServerEncoding(f.name)
+		conn, err := f.c.cmdTransferFrom(f.c.typeFor(f.name, uint64(f.offset)), uint64(f.offset), "RETR %s", path)
+		if err != nil {
+			return 0, opError("RETR", f.name, err)
+		}
+		// the transfer may be cut short by Seek or Close
+		f.r = &rangeResponse{response: response{conn, f.c}, r: conn}
+	}
+
+	n, err := f.r.Read(buf)
+	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		err = opError("RETR", f.name, err)
+	}
+	return n, err
+}
+
+// Write implements the io.Writer interface, issuing STOR or APPE on first
+// use.
+func (f *File) Write(buf []byte) (int, error) {
+	if f.flag&os.O_WRONLY == 0 {
+		return 0, opError(f.writeOp(), f.name, errors.New("ftp: file not opened for writing"))
+	}
+
+	if f.w == nil {
+		pr, pw := io.Pipe()
+		f.w = pw
+		f.done = make(chan error, 1)
+		go func(offset int64) {
+			var err error
+			if f.flag&os.O_APPEND != 0 {
+				err = f.c.Append(f.name, pr)
+			} else {
+				err = f.c.StorFrom(f.name, pr, uint64(offset))
+			}
+			pr.CloseWithError(err)
+			f.done <- err
+		}(f.offset)
+	}
+
+	n, err := f.w.Write(buf)
+	f.offset += int64(n)
+	return n, opError(f.writeOp(), f.name, err)
+}
+
+// writeOp returns the command issued by Write.
+func (f *File) writeOp() string {
+	if f.flag&os.O_APPEND != 0 {
+		return "APPE"
+	}
+	return "STOR"
+}
+
+// Seek implements the io.Seeker interface. The pending transfer is finished
+// and the next Read or Write restarts at the new offset.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.finish(); err != nil {
+		return f.offset, err
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.c.FileSize(f.name)
+		if err != nil {
+			return f.offset, err
+		}
+		offset += size
+	default:
+		return f.offset, opError("REST", f.name, errors.New("ftp: invalid whence"))
+	}
+	if offset < 0 {
+		return f.offset, opError("REST", f.name, errors.New("ftp: negative position"))
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Stat returns the FileInfo describing the file, finishing the pending
+// transfer first. Without MLST, it is built from the SIZE and MDTM replies,
+// the time being left zero if MDTM fails.
+func (f *File) Stat() (os.FileInfo, error) {
+	if err := f.finish(); err != nil {
+		return nil, err
+	}
+	if _, mlstSupported := f.c.feature("MLST"); mlstSupported {
+		fi, err := f.c.Lstat(f.name)
+		if err != nil {
+			return nil, opError("MLST", f.name, err)
+		}
+		return fi, nil
+	}
+
+	size, err := f.c.FileSize(f.name)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Name: path.Base(f.name), Type: EntryTypeFile, Size: uint64(size)}
+	if t, err := f.c.ModTime(f.name); err == nil {
+		e.Time = t
+	}
+	return e.FileInfo(), nil
+}
+
+// Close finishes the pending transfer. For writes, its error tells whether
+// the upload was successful.
+func (f *File) Close() error {
+	return f.finish()
+}
+
+// finish closes the pending download or completes the pending upload.
+func (f *File) finish() error {
+	var err error
+	if f.r != nil {
+		err = opError("RETR", f.name, f.r.Close())
+		f.r = nil
+	}
+	if f.w != nil {
+		f.w.Close()
+		err = opError(f.writeOp(), f.name, <-f.done)
+		f.w = nil
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package ftp
+
+import (
+	"io/fs"
+	"path"
+)
+
+// PutFS uploads the file tree of fsys to the remote directory remoteRoot,
+// which is created if needed, like a recursive Stor. Existing remote
+// directories are reused and existing files are overwritten. Entries other
+// than regular files and directories, such as symbolic links, are skipped.
+func (c *ServerConn) PutFS(fsys fs.FS, remoteRoot string) error {
+	p := c.newProgress("PutFS")
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		remote := path.Join(remoteRoot, name)
+
+		switch {
+		case d.IsDir():
+			if err := c.ensureDir(remote); err != nil {
+				return err
+			}
+			p.done(remote, 0)
+		case d.Type().IsRegular():
+			f, err := fsys.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			cr := &countingReader{r: f}
+			if err := c.Stor(remote, cr); err != nil {
+				return err
+			}
+			p.done(remote, cr.n)
+		}
+		return nil
+	})
+}
+
+// ensureDir creates the remote directory unless it exists already.
+func (c *ServerConn) ensureDir(dir string) error {
+	err := c.MakeDir(dir)
+	if _, ok := replyError(err); ok {
+		// most servers reject MKD for an existing directory, which CWD tells
+		// from a missing one, unlike NLST for an empty directory
+		cwd, err2 := c.CurrentDir()
+		if err2 == nil && c.ChangeDir(dir) == nil {
+			return c.ChangeDir(cwd)
+		}
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package ftp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnsureDir(t *testing.T) {
+	for _, test := range []struct {
+		cwd      string
+		ok       bool
+		commands []string
+	}{
+		{"250 ok", true, []string{"MKD /empty", "PWD", "CWD /empty", "CWD /home"}},
+		{"550 not found", false, []string{"MKD /empty", "PWD", "CWD /empty"}},
+	} {
+		c, commands := scriptedConn(map[string]string{
+			"MKD": "550 exists",
+			"PWD": `257 "/home"`,
+			"CWD": test.cwd,
+		})
+		err := c.ensureDir("/empty")
+		if (err == nil) != test.ok {
+			t.Errorf("ensureDir() with CWD %q = %v", test.cwd, err)
+		}
+		c.Close()
+		var got []string
+		for cmd := range commands {
+			got = append(got, cmd)
+		}
+		if !reflect.DeepEqual(got, test.commands) {
+			t.Errorf("commands = %q, want %q", got, test.commands)
+		}
+	}
+}
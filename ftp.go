@@ -3,6 +3,8 @@ package ftp
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +14,8 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,36 +30,233 @@ const (
 type EntryType int
 
 const (
-	EntryTypeFile   EntryType = iota // file
-	EntryTypeFolder                  // directory
-	EntryTypeLink                    // symlink
+	EntryTypeFile        EntryType = iota // file
+	EntryTypeFolder                       // directory
+	EntryTypeLink                         // symlink
+	EntryTypeSocket                       // unix domain socket
+	EntryTypeFifo                         // named pipe
+	EntryTypeCharDevice                   // character device
+	EntryTypeBlockDevice                  // block device
 )
 
 // ServerConn represents the connection to a remote FTP server.
+//
+// Its methods may be called from several goroutines: the command/reply
+// exchanges are serialized, and so are the commands a method issues
+// together, such as TYPE and a transfer command or RNFR and RNTO. The
+// methods setting up the session (Login, AuthTLS, Logout, Host, SetMListFacts
+// and SetHashAlgorithm) issue several commands which may be interleaved with
+// those of other goroutines, and should complete before the connection is
+// shared. As the server handles one transfer at a time, commands issued
+// while a transfer is in progress fail with ErrTransferInProgress. The
+// exported fields must not be changed concurrently with the use of the
+// connection.
 type ServerConn struct {
-	conn     *textproto.Conn
+	conn *textproto.Conn
+	// the connection under conn, a *tls.Conn once secured
+	netConn  net.Conn
 	host     string
 	features map[string]string
+	// dials the data connections, see Dialer.DialContext
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// greeting and SYST reply of the server, and the detected profile name
+	greeting string
+	system   string
+	profile  string
+
+	// idle timeout negotiated with SITE IDLE, zero if unknown
+	idleTimeout time.Duration
+	// counts the bytes read on the control connection for the current reply
+	limit *limitReader
+	// copy of the control connection traffic, see Dialer.DebugOutput
+	debug io.Writer
+	// TLS configuration of the control connection, nil if not secured
+	tlsConfig *tls.Config
+	// data channel protection level set with PROT, empty if not set yet
+	dataProt string
+	// the server acts as TLS client on the data connections (SSCN ON)
+	sscn bool
+	// serializes the command/reply exchanges, the transfer commands until
+	// their data connection is established
+	cmdMu sync.Mutex
+	// a data transfer is in progress, until its final reply is read
+	transferring bool
+	// state restored by AutoReconnect, one reconnection at a time
+	session      session
+	reconnectMu  sync.Mutex
+	reconnecting int32
+	// open data connections, closed by Close
+	dataMu    sync.Mutex
+	dataConns map[*dataConn]struct{}
+	// counts the bytes of the data connections for a Pool, nil otherwise
+	transferred *int64
+	// guards the features, the history, the last reply and the idle
+	// timeout, which are read without holding cmdMu
+	stateMu sync.Mutex
+
+	// maximum number of bytes buffered for a single reply on the control
+	// connection, zero means no limit
+	MaxResponseSize int64
+	// maximum length of a single line of a directory listing, zero means no limit
+	MaxListLineSize int
+
+	// number of commands kept in History, zero disables the history
+	HistorySize int
+	history     history
+	lastReply   Reply
 
 	// translate filename encoding from/to ISO 8859-15 if server does not support UTF-8
 	TranslateEncoding bool
 	// list "." and ".."
 	ListDotDirs bool
+	// workarounds for the server implementation, detected by Connect
+	Quirks
+	// time zone of the timestamps of LIST lines, typically the zone of the
+	// server or UTC; nil means time.Local
+	Location *time.Location
+	// called with the raw line and the error for each listing line List and
+	// MList fail to parse, instead of silently dropping it
+	OnParseError func(line string, err error)
+	// called after each entry processed by Walk, PutFS, Archive, Unarchive
+	// and Manifest, so that long operations can report their progress
+	OnProgress func(p Progress)
+	// extensions (such as ".txt", case insensitive) of the files transferred
+	// in ASCII mode (TYPE A) by Retr, Stor and the helpers built on them, all
+	// other files being transferred in binary mode; transfers starting at an
+	// offset are always binary. As their size differs on both sides, Get and
+	// Put neither resume nor verify ASCII files, and Archive and Manifest
+	// always read the files in binary mode
+	ASCIIExtensions []string
+	// current transfer type, empty if unknown
+	transferType string
+	// MLSx facts selected with SetMListFacts
+	mlstFacts []string
+
+	// retries of List, MList and NameList after transient negative replies,
+	// such as 450 or 426, each attempt with a new data connection
+	ListRetry RetryPolicy
+	// reopen the control connection when it breaks, e.g. closed by the
+	// server after an idle timeout, restoring the login, the current
+	// directory, the transfer type and the data protection, and issue the
+	// failed command again if it was not sent or only queries or sets the
+	// session, e.g. not DELE or MKD which the server may have executed;
+	// commands which already started a transfer are not retried. It
+	// requires a connection opened by a Dialer; the current directory is
+	// queried with PWD after each change.
+	AutoReconnect bool
+
+	// IP TOS byte (DSCP << 2) set on the data connections, or the traffic
+	// class for IPv6; zero leaves the system default. 0x20 (CS1) marks the
+	// transfers as scavenger class.
+	DataTOS int
+	// called with each data connection once established, before it is used;
+	// the returned connection is used instead, e.g. to throttle or
+	// instrument the transfers
+	DataConnHook func(conn net.Conn) (net.Conn, error)
+
+	// maximum time of each connection attempt, see Dialer.Timeout
+	dialTimeout time.Duration
+	// maximum time of each read and write on the control connection, so
+	// that a server which stops responding doesn't block forever; zero
+	// means no timeout
+	ControlTimeout time.Duration
+	// maximum duration of each data connection, from the transfer command
+	// to the end of the transfer; zero means no timeout
+	DataTimeout time.Duration
+	// maximum time without progress of a transfer: the deadline of the
+	// data connection is pushed forward on each read and write, and a
+	// stalled transfer fails with ErrTransferStalled; zero means no timeout
+	DataIdleTimeout time.Duration
+	// open the data connections in active mode: the server connects to a
+	// port the client listens on, advertised with PORT or EPRT
+	ActiveMode bool
+	// range of the local ports listened on in active mode, zero meaning
+	// any port
+	ActivePortMin int
+	ActivePortMax int
+	// dial the address of the PASV replies instead of the host of the
+	// control connection; it is ignored by default, as servers behind NAT
+	// often advertise private addresses. To prevent bounce attacks, the
+	// address must be the one of the server or belong to PASVAllowlist.
+	UsePASVAddress bool
+	PASVAllowlist  []*net.IPNet
+
+	// commands used to open passive data connections
+	PassiveMode PassiveMode
+	// EPSV was rejected in PassiveEPSVFirst mode
+	epsvRejected bool
+	// issue EPSV ALL before the first passive data connection if the
+	// server advertises EPSV, locking the session to EPSV as recommended by
+	// RFC 2428 for firewalls; it does not apply to ActiveMode
+	UseEPSVAll bool
+	// EPSV ALL has been sent
+	epsvAll bool
+
+	// address advertised in active mode instead of the local one, e.g. the
+	// public address of a NAT gateway forwarding the port range; if nil and
+	// DiscoverExternalIP is set, the latter is called once to find it out
+	ActiveExternalIP   net.IP
+	DiscoverExternalIP func() (net.IP, error)
+
+	// version of the PROXY protocol header sent on the data connections,
+	// see Dialer.ProxyProtocol
+	proxyProtocol int
+	// socket options of the data connections, see Dialer.SocketHook
+	socketHook func(conn *net.TCPConn, data bool) error
+}
+
+const (
+	// DefaultMaxResponseSize is the default value of ServerConn.MaxResponseSize
+	DefaultMaxResponseSize = 1 << 20
+	// DefaultMaxListLineSize is the default value of ServerConn.MaxListLineSize
+	DefaultMaxListLineSize = 64 << 10
+)
+
+// ErrResponseTooLarge is returned when a reply or a listing line sent by the
+// server exceeds the configured limits. The connection should not be used
+// anymore after this error.
+var ErrResponseTooLarge = errors.New("ftp: response too large")
+
+// Reply is a reply of the server on the control connection.
+type Reply struct {
+	Code    int
+	Message string
 }
 
-// Entry describes a file and is returned by List().
+// Entry describes a file and is returned by List() and ListEntries().
 type Entry struct {
 	Name string
 	Type EntryType
 	Size uint64
 	Time time.Time
+	// Target is the path the entry points to if it is a symbolic link
+	Target string
+	// Mode holds the permission and type bits, as far as the server tells
+	Mode os.FileMode
+	// Owner and Group are the names (or ids) of the owner, if known
+	Owner string
+	Group string
+	// Facts are the MLSx facts of the entry (lowercase keys), nil for LIST
+	Facts map[string]string
+	// Raw is the listing line sent by the server, as received
+	Raw string
+
+	// the time was parsed from the ls format, which omits the year or the
+	// time of day
+	approxTime bool
 }
 
 // EntryEx describes a file and is returned by MList() and MInfo().
 // EntryEx implements the FileInfo interface
+//
+// Deprecated: use Entry, returned by ListEntries for both MLSD and LIST
+// listings, and its FileInfo method.
 type EntryEx struct {
 	// name of the file
 	name string
+	// line sent by the server
+	raw string
 	// facts describing the file. Keys for standard facts (lowercase): size, modify, create, type (), unique, perm, lang, media-type, charset
 	Facts map[string]string
 }
@@ -96,10 +297,7 @@ func (e EntryEx) Mode() os.FileMode {
 			mode += 0200
 		}
 	}
-	if e.IsDir() {
-		mode += os.ModeDir
-	}
-	return mode
+	return mode | e.Type().mode()
 }
 
 // ModTime returns the last modified time
@@ -115,6 +313,19 @@ func (e EntryEx) ModTime() time.Time {
 	return modify
 }
 
+// CreateTime returns the creation time
+func (e EntryEx) CreateTime() time.Time {
+	sCreate, exists := e.Facts["create"]
+	if !exists {
+		return time.Unix(0, 0)
+	}
+	create, err := ParseMListTime(sCreate)
+	if err != nil {
+		return time.Unix(0, 0)
+	}
+	return create
+}
+
 // IsDir
 func (e EntryEx) IsDir() bool {
 	eType, exists := e.Facts["type"]
@@ -124,9 +335,40 @@ func (e EntryEx) IsDir() bool {
 	return (eType == "dir") || (eType == "cdir") || (eType == "pdir")
 }
 
-// Sys returns the underlying data source (can and does return nil)
+// Type returns the type of the entry, derived from the "type" fact. Special
+// files are reported by servers as "OS.unix=<kind>" types.
+func (e EntryEx) Type() EntryType {
+	eType := strings.ToLower(e.Facts["type"])
+	switch {
+	case e.IsDir():
+		return EntryTypeFolder
+	case strings.HasPrefix(eType, "os.unix=slink"), strings.HasPrefix(eType, "os.unix=symlink"):
+		return EntryTypeLink
+	case strings.HasPrefix(eType, "os.unix=socket"):
+		return EntryTypeSocket
+	case strings.HasPrefix(eType, "os.unix=fifo"):
+		return EntryTypeFifo
+	case strings.HasPrefix(eType, "os.unix=chr"), strings.HasPrefix(eType, "os.unix=char"):
+		return EntryTypeCharDevice
+	case strings.HasPrefix(eType, "os.unix=blk"), strings.HasPrefix(eType, "os.unix=block"):
+		return EntryTypeBlockDevice
+	}
+	return EntryTypeFile
+}
+
+// Sys returns the underlying data source: the MLSx line sent by the server
+// as a string, so that server-specific facts can be accessed, or nil if the
+// entry was not parsed from a listing.
 func (e EntryEx) Sys() interface{} {
-	return nil
+	if e.raw == "" {
+		return nil
+	}
+	return e.raw
+}
+
+// Raw returns the MLSx line sent by the server, as received.
+func (e EntryEx) Raw() string {
+	return e.raw
 }
 
 // response represent a data-connection
@@ -140,35 +382,106 @@ type response struct {
 // It is generally followed by a call to Login() as most FTP commands require
 // an authenticated user.
 func Connect(addr string) (*ServerConn, error) {
-	conn, err := textproto.Dial("tcp", addr)
+	var d Dialer
+	return d.Connect(addr)
+}
+
+// System issues a SYST FTP command, which returns the system type of the
+// remote FTP server.
+func (c *ServerConn) System() (string, error) {
+	_, msg, err := c.cmd(StatusName, "SYST")
 	if err != nil {
-		return nil, err
+		return "", opError("SYST", "", err)
 	}
+	return msg, nil
+}
 
-	host, _, err := net.SplitHostPort(addr)
+// ServerStatus is the status of the session, as returned by Status. The
+// fields are empty when the server does not report them.
+type ServerStatus struct {
+	// lines of the reply, without the first and last one
+	Lines []string
+	// user the session is logged in as
+	User string
+	// transfer parameters, as worded by the server (e.g. "BINARY")
+	Type      string
+	Structure string
+	Mode      string
+	// line describing the data connection, e.g. "No data connection"
+	DataConnection string
+	// server software and version, e.g. "vsFTPd 3.0.3"
+	Version string
+}
+
+// Status issues a STAT FTP command without argument and parses the status of
+// the session returned by the server.
+func (c *ServerConn) Status() (*ServerStatus, error) {
+	_, msg, err := c.cmd(StatusSystem, "STAT")
 	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	c := &ServerConn{
-		conn:     conn,
-		host:     host,
-		features: make(map[string]string),
+		return nil, opError("STAT", "", err)
 	}
+	return parseStatus(msg), nil
+}
 
-	_, _, err = c.conn.ReadResponse(StatusReady)
-	if err != nil {
-		c.Quit()
-		return nil, err
+// parseStatus parses the reply to a bare STAT. The wording is not
+// standardized, the lines used by the common servers are recognized.
+func parseStatus(msg string) *ServerStatus {
+	lines := strings.Split(msg, "\n")
+	if len(lines) > 2 {
+		lines = lines[1 : len(lines)-1]
+	} else {
+		lines = nil
 	}
 
-	err = c.feat()
-	if err != nil {
-		c.Quit()
-		return nil, err
+	st := &ServerStatus{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		st.Lines = append(st.Lines, line)
+		lower := strings.ToLower(line)
+
+		switch {
+		case strings.HasPrefix(lower, "logged in as "):
+			st.User = strings.TrimSuffix(line[len("logged in as "):], ".")
+		case strings.HasPrefix(lower, "type:"):
+			// e.g. "TYPE: BINARY, STRUcture: File, Mode: Stream"
+			for _, param := range strings.Split(line, ",") {
+				kv := strings.SplitN(param, ":", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value := strings.TrimSpace(kv[1])
+				switch strings.ToLower(strings.TrimSpace(kv[0])) {
+				case "type":
+					st.Type = value
+				case "structure", "stru":
+					st.Structure = value
+				case "mode":
+					st.Mode = value
+				}
+			}
+		case strings.Contains(lower, "data connection"):
+			if st.DataConnection == "" {
+				st.DataConnection = line
+			}
+		case st.Version == "" && isVersionLine(line):
+			st.Version = strings.SplitN(line, " - ", 2)[0]
+		}
 	}
+	return st
+}
 
-	return c, nil
+// isVersionLine reports whether line looks like "<name> <version>...", the
+// version starting with a digit and containing a dot.
+func isVersionLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	v := strings.TrimPrefix(fields[1], "v")
+	return v != "" && v[0] >= '0' && v[0] <= '9' && strings.Contains(v, ".")
 }
 
 // Login authenticates the client with specified user and password.
@@ -176,40 +489,133 @@ func Connect(addr string) (*ServerConn, error) {
 // "anonymous"/"anonymous" is a common user/password scheme for FTP servers
 // that allows anonymous read-only accounts.
 func (c *ServerConn) Login(user, password string) error {
+	return c.LoginChallenge(user, passwordOnly(password))
+}
+
+// passwordOnly answers the password challenge of a login with password.
+func passwordOnly(password string) func(code int, message string) (string, error) {
+	return func(code int, message string) (string, error) {
+		if code == StatusLoginNeedAccount {
+			return "", errors.New(message)
+		}
+		return password, nil
+	}
+}
+
+// maxLoginSteps bounds the number of challenges accepted by LoginChallenge.
+const maxLoginSteps = 8
+
+// LoginChallenge authenticates the client with the specified user, for
+// multi-step logins such as one-time passwords or security tokens: each time
+// the server asks for more (331 for a password, 332 for an account), respond
+// is called with the reply code and message containing the challenge, and
+// its result is sent with PASS or ACCT respectively.
+func (c *ServerConn) LoginChallenge(user string, respond func(code int, message string) (string, error)) error {
+	if err := c.login(user, respond); err != nil {
+		return err
+	}
+	c.session.auth = ChallengeResponse{user, respond}
+
+	// Switch to binary mode
+	c.cmdMu.Lock()
+	c.transferType = ""
+	c.cmdMu.Unlock()
+	return opError("TYPE", "", c.setType("I"))
+}
+
+// login issues USER, then PASS and ACCT as long as the server asks for them.
+func (c *ServerConn) login(user string, respond func(code int, message string) (string, error)) error {
 	code, message, err := c.cmd(-1, "USER %s", user)
 	if err != nil {
-		return err
+		return opError("USER", "", err)
 	}
 
-	switch code {
-	case StatusLoggedIn:
-	case StatusUserOK:
-		_, _, err = c.cmd(StatusLoggedIn, "PASS %s", password)
+	command := "USER"
+	for step := 0; code != StatusLoggedIn && code != StatusCommandNotImplemented; step++ {
+		if step == maxLoginSteps {
+			return opError(command, "", errors.New("too many login challenges"))
+		}
+
+		switch code {
+		case StatusUserOK:
+			command = "PASS"
+		case StatusLoginNeedAccount:
+			command = "ACCT"
+		default:
+			return opError(command, "", &textproto.Error{Code: code, Msg: message})
+		}
+
+		response, err := respond(code, message)
 		if err != nil {
-			return err
+			return opError(command, "", err)
+		}
+		code, message, err = c.cmd(-1, "%s %s", command, response)
+		if err != nil {
+			return opError(command, "", err)
 		}
-	default:
-		return errors.New(message)
 	}
+	return nil
+}
 
-	// Switch to binary mode
-	_, _, err = c.cmd(StatusCommandOK, "TYPE I")
-	if err != nil {
-		return err
+// setType issues a TYPE FTP command if the transfer type differs from t.
+func (c *ServerConn) setType(t string) error {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if c.transferring {
+		return ErrTransferInProgress
 	}
+	return c.switchType(t)
+}
 
+// switchType issues a TYPE FTP command if the transfer type differs from t,
+// which may be empty to keep the current type, the caller holding cmdMu.
+func (c *ServerConn) switchType(t string) error {
+	if t == "" || c.transferType == t {
+		return nil
+	}
+	if _, _, err := c.exchange(StatusCommandOK, "TYPE %s", t); err != nil {
+		return err
+	}
+	c.transferType = t
 	return nil
 }
 
+// typeFor returns the transfer type for a transfer of the specified file
+// starting at offset, according to ASCIIExtensions.
+func (c *ServerConn) typeFor(name string, offset uint64) string {
+	if offset == 0 && c.isASCII(name) {
+		return "A"
+	}
+	return "I"
+}
+
+// isASCII reports whether the specified file is transferred in ASCII mode
+// from its start, according to ASCIIExtensions.
+func (c *ServerConn) isASCII(name string) bool {
+	ext := path.Ext(name)
+	for _, e := range c.ASCIIExtensions {
+		if ext != "" && strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
 // feat issues a FEAT FTP command to list the additional commands supported by
 // the remote FTP server.
 // FEAT is described in RFC 2389
 func (c *ServerConn) feat() error {
 	code, message, err := c.cmd(-1, "FEAT")
 	if err != nil {
-		return err
+		return opError("FEAT", "", err)
 	}
 
+	features := make(map[string]string)
+	defer func() {
+		c.stateMu.Lock()
+		c.features = features
+		c.stateMu.Unlock()
+	}()
 	if code != StatusSystem {
 		// The server does not support the FEAT command. This is not an
 		// error: we consider that there is no additional feature.
@@ -232,16 +638,103 @@ func (c *ServerConn) feat() error {
 			commandDesc = featureElements[1]
 		}
 
-		c.features[command] = commandDesc
+		features[command] = commandDesc
+	}
+
+	return nil
+}
+
+// feature returns the parameters of a feature advertised by the server, and
+// whether it is advertised.
+func (c *ServerConn) feature(name string) (string, bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	desc, ok := c.features[name]
+	return desc, ok
+}
+
+// setFeature updates the parameters of a feature advertised by the server.
+func (c *ServerConn) setFeature(name, desc string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.features[name] = desc
+}
+
+// refreshFeatures discards the known features and issues FEAT again, then
+// enables UTF-8 if the server supports it. It is called whenever the session
+// state which the features depend on is reset.
+func (c *ServerConn) refreshFeatures() error {
+	if err := c.feat(); err != nil {
+		return err
+	}
+
+	if _, utf8Supported := c.feature("UTF8"); utf8Supported {
+		// some servers enable UTF-8 by default and reject the command
+		c.cmd(-1, "OPTS UTF8 ON")
+	}
+	if c.mlstFacts != nil {
+		// the selection does not survive REIN
+		c.SetMListFacts(c.mlstFacts...)
+	}
+	return nil
+}
+
+// MListFacts returns the facts sent by the server in MLSx listings, as
+// advertised by the MLST feature.
+func (c *ServerConn) MListFacts() []string {
+	var facts []string
+	desc, _ := c.feature("MLST")
+	for _, fact := range strings.Split(desc, ";") {
+		if strings.HasSuffix(fact, "*") {
+			facts = append(facts, strings.TrimSuffix(fact, "*"))
+		}
+	}
+	return facts
+}
+
+// SetMListFacts issues an OPTS MLST FTP command to select the facts sent by
+// the server in MLSx listings. The selection is restored after Logout.
+// OPTS MLST is described in RFC 3659
+func (c *ServerConn) SetMListFacts(facts ...string) error {
+	desc, mlstSupported := c.feature("MLST")
+	if !mlstSupported {
+		return opError("OPTS", "", errors.New("MLST not supported by server"))
+	}
+	_, _, err := c.cmd(StatusCommandOK, "OPTS MLST %s", strings.Join(facts, ";")+";")
+	if err != nil {
+		return opError("OPTS", "", err)
 	}
+	c.mlstFacts = facts
 
+	// keep the advertised list in sync with the new selection
+	var updated []string
+	for _, fact := range strings.Split(desc, ";") {
+		fact = strings.TrimSuffix(fact, "*")
+		if fact == "" {
+			continue
+		}
+		for _, f := range facts {
+			if strings.EqualFold(f, fact) {
+				fact += "*"
+				break
+			}
+		}
+		updated = append(updated, fact)
+	}
+	c.setFeature("MLST", strings.Join(updated, ";")+";")
 	return nil
 }
 
 // converts a string from UTF-8 to the encoding used by the server
 // (if the server doesn't support UTF-8, ISO8859-15 is assumed)
+//
+// If the server advertises TVFS, the path is cleaned first, as its elements
+// are known to be separated by slashes.
 func (c *ServerConn) toServerEncoding(s string) string {
-	_, utf8Supported := c.features["UTF8"]
+	if _, tvfsSupported := c.feature("TVFS"); tvfsSupported && s != "" {
+		s = path.Clean(s)
+	}
+	_, utf8Supported := c.feature("UTF8")
 	if !utf8Supported && c.TranslateEncoding {
 		s = UTF8ToISO8859_15(s)
 	}
@@ -251,7 +744,7 @@ func (c *ServerConn) toServerEncoding(s string) string {
 // converts a string from the encoding used by the server to UTF-8
 // (if the server doesn't support UTF-8, ISO8859-15 is assumed)
 func (c *ServerConn) fromServerEncoding(s string) string {
-	_, utf8Supported := c.features["UTF8"]
+	_, utf8Supported := c.feature("UTF8")
 	if !utf8Supported && c.TranslateEncoding {
 		s = ISO8859_15ToUTF8(s)
 	}
@@ -260,7 +753,7 @@ func (c *ServerConn) fromServerEncoding(s string) string {
 
 // epsv issues an "EPSV" command to get a port number for a data connection.
 func (c *ServerConn) epsv() (port int, err error) {
-	_, line, err := c.cmd(StatusExtendedPassiveMode, "EPSV")
+	_, line, err := c.exchange(StatusExtendedPassiveMode, "EPSV")
 	if err != nil {
 		return
 	}
@@ -275,9 +768,10 @@ func (c *ServerConn) epsv() (port int, err error) {
 	return
 }
 
-// pasv issues a "PASV" command to get a port number for a data connection.
-func (c *ServerConn) pasv() (port int, err error) {
-	_, line, err := c.cmd(StatusPassiveMode, "PASV")
+// pasv issues a "PASV" command to get the address and the port number for a
+// data connection.
+func (c *ServerConn) pasv() (host string, port int, err error) {
+	_, line, err := c.exchange(StatusPassiveMode, "PASV")
 	if err != nil {
 		return
 	}
@@ -286,12 +780,22 @@ func (c *ServerConn) pasv() (port int, err error) {
 	start := strings.Index(line, "(")
 	end := strings.LastIndex(line, ")")
 	if start == -1 || end == -1 {
-		err = errors.New("invalid EPSV response format")
+		err = errors.New("invalid PASV response format")
 		return
 	}
 
 	// We have to split the response string
 	pasvData := strings.Split(line[start+1:end], ",")
+	if len(pasvData) != 6 {
+		err = errors.New("invalid PASV response format")
+		return
+	}
+	ip := strings.Join(pasvData[:4], ".")
+	if net.ParseIP(ip).To4() == nil {
+		err = errors.New("invalid PASV response format")
+		return
+	}
+
 	// Let's compute the port number
 	portPart1, err1 := strconv.Atoi(pasvData[4])
 	if err1 != nil {
@@ -306,85 +810,391 @@ func (c *ServerConn) pasv() (port int, err error) {
 	}
 
 	// Recompose port
-	port = portPart1*256 + portPart2
+	host, port = ip, portPart1*256+portPart2
 	return
 }
 
-// openDataConn creates a new FTP data connection.
-func (c *ServerConn) openDataConn() (net.Conn, error) {
-	var port int
-	var err error
+// ErrPASVAddress is returned when the address of a PASV reply is neither the
+// one of the server nor in ServerConn.PASVAllowlist.
+var ErrPASVAddress = errors.New("ftp: PASV address does not match the server")
+
+// checkPASVAddress checks that ip, the address of a PASV reply, is the
+// address of the control connection peer or is allowed by PASVAllowlist.
+func (c *ServerConn) checkPASVAddress(ip net.IP) error {
+	if remote, ok := c.netConn.RemoteAddr().(*net.TCPAddr); ok && remote.IP.Equal(ip) {
+		return nil
+	}
+	for _, n := range c.PASVAllowlist {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrPASVAddress
+}
+
+// controlIPv6 reports whether the control connection uses IPv6.
+func (c *ServerConn) controlIPv6() bool {
+	remote, ok := c.netConn.RemoteAddr().(*net.TCPAddr)
+	return ok && remote.IP.To4() == nil
+}
+
+// PassiveMode selects the commands used to open passive data connections.
+type PassiveMode int
+
+const (
+	// EPSV, falling back to PASV for the rest of the session if the server
+	// rejects it with 500 or 502
+	PassiveEPSVFirst PassiveMode = iota
+	// EPSV only, as required for IPv6
+	PassiveEPSVOnly
+	// PASV only, e.g. for servers which accept EPSV but can not be
+	// reached on the announced port
+	PassivePASVOnly
+)
+
+// passive issues EPSV or PASV, according to PassiveMode, to get the address
+// (empty for EPSV) and the port of a passive data connection. As PASV only
+// supports IPv4, PassiveEPSVFirst means EPSV only on IPv6 control
+// connections; otherwise the DisableEPSV quirk turns it into
+// PassivePASVOnly. Once EPSV ALL has been sent only EPSV is used.
+func (c *ServerConn) passive() (host string, port int, err error) {
+	mode := c.PassiveMode
+	if mode == PassiveEPSVFirst {
+		if c.controlIPv6() {
+			mode = PassiveEPSVOnly
+		} else if c.DisableEPSV {
+			mode = PassivePASVOnly
+		}
+	}
 
-	//  If features contains nat6 or EPSV => EPSV
-	//  else -> PASV
-	_, nat6Supported := c.features["nat6"]
-	_, epsvSupported := c.features["EPSV"]
+	_, epsvSupported := c.feature("EPSV")
+	if c.UseEPSVAll && epsvSupported && !c.epsvAll && mode != PassivePASVOnly {
+		// EPSV ALL is described in RFC 2428
+		if _, _, err := c.exchange(2, "EPSV ALL"); err != nil {
+			return "", 0, err
+		}
+		c.epsvAll = true
+	}
+	if c.epsvAll {
+		mode = PassiveEPSVOnly
+	}
 
-	if !nat6Supported && !epsvSupported {
-		port, _ = c.pasv()
+	switch mode {
+	case PassivePASVOnly:
+		return c.pasv()
+	case PassiveEPSVOnly:
+		port, err = c.epsv()
+		return "", port, err
 	}
-	if port == 0 {
+
+	if !c.epsvRejected {
 		port, err = c.epsv()
+		if err == nil {
+			return "", port, nil
+		}
+		reply, ok := replyError(err)
+		if !ok || reply.Code != StatusBadCommand && reply.Code != StatusNotImplemented {
+			return "", 0, err
+		}
+		c.epsvRejected = true
+	}
+	return c.pasv()
+}
+
+// openDataConn prepares a new FTP data connection: in passive mode it is
+// established right away, in active mode once the transfer command has been
+// accepted, see pendingDataConn.
+func (c *ServerConn) openDataConn() (*pendingDataConn, error) {
+	if c.tlsConfig != nil && c.dataProt == "" {
+		// protect the data connections like the control connection by
+		// default
+		if err := c.setDataProtection(true); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ActiveMode {
+		ln, err := c.listenActive()
 		if err != nil {
 			return nil, err
 		}
+		return &pendingDataConn{c: c, ln: ln}, nil
+	}
+
+	host, port, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the new net address string, EPSV replies carry no address
+	if !c.UsePASVAddress || host == "" {
+		host = c.host
+	} else if err := c.checkPASVAddress(net.ParseIP(host)); err != nil {
+		return nil, err
 	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("ftp: invalid passive port %d", port)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
-	// Build the new net address string
-	addr := net.JoinHostPort(c.host, strconv.Itoa(port))
+	conn, err := dialTimeout(c.dialTimeout, c.dial, addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.proxyProtocol != 0 {
+		if err := writeProxyHeader(conn, c.proxyProtocol); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err = c.setupDataConn(conn)
 	if err != nil {
 		return nil, err
 	}
+	return &pendingDataConn{c: c, conn: conn}, nil
+}
+
+// setupDataConn applies the settings of the data connections to conn, once
+// established.
+func (c *ServerConn) setupDataConn(conn net.Conn) (net.Conn, error) {
+	if err := applySocketHook(c.socketHook, conn, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var deadline time.Time
+	if c.DataTimeout > 0 {
+		deadline = time.Now().Add(c.DataTimeout)
+		conn.SetDeadline(deadline)
+	}
+	if c.DataTOS != 0 {
+		if err := setTOS(conn, c.DataTOS); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.DataIdleTimeout > 0 {
+		conn = &stallConn{conn, c.DataIdleTimeout, deadline}
+	}
+
+	conn = c.trackDataConn(conn)
+	if c.dataProt == "P" {
+		// the handshake takes place once the transfer command is accepted
+		if c.sscn {
+			conn = tls.Server(conn, c.tlsConfig)
+		} else {
+			conn = tls.Client(conn, c.tlsConfig)
+		}
+	}
+
+	if c.DataConnHook != nil {
+		wrapped, err := c.DataConnHook(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = wrapped
+	}
 
 	return conn, nil
 }
 
+// ErrInvalidArgument is returned when a command argument, such as a path,
+// contains a CR character, or when the line of a Command or DataCommand
+// contains CR or LF, and could therefore inject commands into the control
+// connection.
+var ErrInvalidArgument = errors.New("ftp: CR or LF in command argument")
+
+// sanitizeArgs checks the string arguments of a command before they are sent
+// on the control connection: CR is rejected, LF is encoded as NUL and Telnet
+// IAC bytes are escaped by doubling them as required by RFC 2640.
+func sanitizeArgs(args []interface{}) ([]interface{}, error) {
+	sanitized := make([]interface{}, len(args))
+	for i, arg := range args {
+		if s, ok := arg.(string); ok {
+			if strings.ContainsRune(s, '\r') {
+				return nil, ErrInvalidArgument
+			}
+			// LF is sent as NUL, as described in RFC 3659 section 2.2
+			s = strings.Replace(s, "\n", "\x00", -1)
+			arg = strings.Replace(s, "\xff", "\xff\xff", -1)
+		}
+		sanitized[i] = arg
+	}
+	return sanitized, nil
+}
+
+// ErrTransferInProgress is returned when a command is issued while a data
+// transfer is in progress on the connection, e.g. before the ReadCloser
+// returned by Retr is closed. The command is not sent.
+var ErrTransferInProgress = errors.New("ftp: data transfer in progress")
+
 // cmd is a helper function to execute a command and check for the expected FTP
-// return code
+// return code. With AutoReconnect, the command is issued again once
+// reconnected if the control connection was broken, unless it was sent and
+// is not replayable.
 func (c *ServerConn) cmd(expected int, format string, args ...interface{}) (int, string, error) {
-	_, err := c.conn.Cmd(format, args...)
+	code, msg, sent, err := c.cmdOnce(expected, format, args...)
+	if c.canReconnect(err) && c.reconnect() == nil && (!sent || replayable(format)) {
+		code, msg, _, err = c.cmdOnce(expected, format, args...)
+	}
+	return code, msg, err
+}
+
+// cmdOnce executes a command like cmd, without reconnecting, and reports
+// whether it was sent.
+func (c *ServerConn) cmdOnce(expected int, format string, args ...interface{}) (int, string, bool, error) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if c.transferring {
+		return 0, "", false, ErrTransferInProgress
+	}
+	h, err := c.send(format, args...)
+	if err != nil {
+		return 0, "", false, err
+	}
+	code, line, err := c.receive(expected, h)
+	return code, line, true, err
+}
+
+// exchange executes a command like cmd, the caller holding cmdMu.
+func (c *ServerConn) exchange(expected int, format string, args ...interface{}) (int, string, error) {
+	h, err := c.send(format, args...)
 	if err != nil {
 		return 0, "", err
 	}
+	return c.receive(expected, h)
+}
+
+// send writes a command on the control connection, the caller holding cmdMu,
+// and returns its history entry for receive.
+func (c *ServerConn) send(format string, args ...interface{}) (*HistoryEntry, error) {
+	args, err := sanitizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	c.limit.reset(c.MaxResponseSize)
+	h := c.recordCommand(format, args...)
+	_, err = c.conn.Cmd(format, args...)
+	return h, err
+}
 
+// receive reads the reply to the command sent, the caller holding cmdMu.
+func (c *ServerConn) receive(expected int, h *HistoryEntry) (int, string, error) {
 	code, line, err := c.conn.ReadResponse(expected)
+	c.recordReply(h, code, line)
 	return code, line, err
 }
 
+// replayableCommands are the commands which only query the server or set the
+// session, issued again by AutoReconnect even if the server received them.
+var replayableCommands = map[string]bool{
+	"CDUP": true, "CWD": true, "EPSV": true, "FEAT": true, "HELP": true,
+	"LIST": true, "MDTM": true, "MLSD": true, "MLST": true, "MODE": true,
+	"NLST": true, "NOOP": true, "OPTS": true, "PASV": true, "PBSZ": true,
+	"PROT": true, "PWD": true, "RETR": true, "SIZE": true, "STAT": true,
+	"STRU": true, "SYST": true, "TYPE": true,
+}
+
+// replayable reports whether the command of format may be issued again after
+// a reconnection although the server may have received it.
+func replayable(format string) bool {
+	return replayableCommands[commandVerb(format)]
+}
+
 // cmdDataConnFrom executes a command which requires a FTP data connection.
 // Issues a REST FTP command to specify the number of bytes to skip for the transfer.
 func (c *ServerConn) cmdDataConnFrom(offset uint64, format string, args ...interface{}) (net.Conn, error) {
-	conn, err := c.openDataConn()
+	return c.cmdTransferFrom("", offset, format, args...)
+}
+
+// cmdTransferFrom executes a command which requires a FTP data connection
+// like cmdDataConnFrom, selecting the transfer type t first, unless empty,
+// without releasing the control connection in between.
+func (c *ServerConn) cmdTransferFrom(t string, offset uint64, format string, args ...interface{}) (net.Conn, error) {
+	var restart string
+	if offset != 0 {
+		restart = fmt.Sprintf("REST %d", offset)
+	}
+	return c.cmdDataConnRestart(t, restart, format, args...)
+}
+
+// cmdDataConnRestart executes a command which requires a FTP data connection,
+// with the transfer type t, unless empty, as cmdTransferFrom.
+// If restart is not empty, it is issued before the command and must be
+// answered with a 350 reply (REST and RANG both are). With AutoReconnect,
+// the command is issued again once reconnected if the control connection
+// was broken before the transfer started, unless it was sent and is not
+// replayable.
+func (c *ServerConn) cmdDataConnRestart(t, restart string, format string, args ...interface{}) (net.Conn, error) {
+	conn, sent, err := c.cmdDataConnOnce(t, restart, format, args...)
+	if c.canReconnect(err) && c.reconnect() == nil && (!sent || replayable(format)) {
+		conn, _, err = c.cmdDataConnOnce(t, restart, format, args...)
+	}
+	return conn, err
+}
+
+// cmdDataConnOnce executes a data command like cmdDataConnRestart, without
+// reconnecting, and reports whether the command was sent.
+func (c *ServerConn) cmdDataConnOnce(t, restart string, format string, args ...interface{}) (net.Conn, bool, error) {
+	if _, err := sanitizeArgs(args); err != nil {
+		return nil, false, err
+	}
+
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if c.transferring {
+		return nil, false, ErrTransferInProgress
+	}
+	if err := c.switchType(t); err != nil {
+		return nil, false, err
+	}
+
+	pending, err := c.openDataConn()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	if offset != 0 {
-		_, _, err := c.cmd(StatusRequestFilePending, "REST %d", offset)
+	if restart != "" {
+		_, _, err := c.exchange(StatusRequestFilePending, "%s", restart)
 		if err != nil {
-			return nil, err
+			pending.Close()
+			return nil, false, err
 		}
 	}
 
-	_, err = c.conn.Cmd(format, args...)
+	h, err := c.send(format, args...)
 	if err != nil {
-		conn.Close()
-		return nil, err
+		pending.Close()
+		return nil, false, err
 	}
 
 	code, msg, err := c.conn.ReadCodeLine(-1)
+	c.recordReply(h, code, msg)
 	if err != nil {
-		conn.Close()
-		return nil, err
+		pending.Close()
+		return nil, true, err
 	}
 	if code != StatusAlreadyOpen && code != StatusAboutToSend {
-		conn.Close()
-		return nil, &textproto.Error{Code: code, Msg: msg}
+		pending.Close()
+		return nil, true, &textproto.Error{Code: code, Msg: msg}
 	}
 
-	return conn, nil
+	conn, err := pending.establish()
+	if err != nil {
+		// the server ends the transfer it could not start, usually with 425
+		code, msg, _ := c.conn.ReadResponse(-1)
+		c.recordReply(h, code, msg)
+		return nil, true, err
+	}
+
+	c.transferring = true
+	c.history.transfer = h
+	if h != nil {
+		conn = &countingConn{conn, h}
+	}
+	return conn, true, nil
 }
 
 // parseListLine parses the various non-standard format returned by the LIST
@@ -402,7 +1212,12 @@ func (c *ServerConn) parseListLine(line string) (*Entry, error) {
 	// 6 - day
 	// 7 - year|hour:min
 
-	e := &Entry{}
+	e := &Entry{
+		Raw:        line,
+		Owner:      fields[2],
+		Group:      fields[3],
+		approxTime: true,
+	}
 	switch fields[0][0] {
 	case '-':
 		e.Type = EntryTypeFile
@@ -410,10 +1225,29 @@ func (c *ServerConn) parseListLine(line string) (*Entry, error) {
 		e.Type = EntryTypeFolder
 	case 'l':
 		e.Type = EntryTypeLink
+	case 's':
+		e.Type = EntryTypeSocket
+	case 'p':
+		e.Type = EntryTypeFifo
+	case 'c':
+		e.Type = EntryTypeCharDevice
+	case 'b':
+		e.Type = EntryTypeBlockDevice
 	default:
 		return nil, errors.New("unknown entry type")
 	}
 
+	// number of fields before the name
+	nameField := 8
+	if (e.Type == EntryTypeCharDevice || e.Type == EntryTypeBlockDevice) && strings.HasSuffix(fields[4], ",") {
+		// devices list "major, minor" instead of the size
+		if len(fields) < 10 {
+			return nil, errors.New("unsupported LIST line")
+		}
+		fields = append(fields[:5], fields[6:]...)
+		nameField++
+	}
+
 	if e.Type == EntryTypeFile {
 		size, err := strconv.ParseUint(fields[4], 10, 0)
 		if err != nil {
@@ -421,8 +1255,13 @@ func (c *ServerConn) parseListLine(line string) (*Entry, error) {
 		}
 		e.Size = size
 	}
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
 	var timeStr string
-	setYear, currMon, _ := time.Now().Date()
+	setYear, currMon, _ := time.Now().In(loc).Date()
 	ts, err := time.Parse("02 Jan 06", "01 "+fields[5]+" 01")
 	if err != nil {
 		return nil, err
@@ -438,61 +1277,147 @@ func (c *ServerConn) parseListLine(line string) (*Entry, error) {
 		// year present, time hidden
 		timeStr = fields[6] + " " + fields[5] + " " + fields[7][2:4] + " " + "00:00"
 	}
-	loc, _ := time.LoadLocation("Local")
 	t, err := time.ParseInLocation("_2 Jan 06 15:04", timeStr, loc)
 	if err != nil {
 		return nil, err
 	}
-	e.Time = t.Local()
+	e.Time = t
 
-	e.Name = c.fromServerEncoding(strings.Join(fields[8:], " "))
+	e.Mode = parseLsMode(fields[0]) | e.Type.mode()
+
+	// the name is taken from the line as is, as it may contain any blank
+	e.Name = c.fromServerEncoding(decodePathname(fieldsRest(line, nameField)))
+	if e.Type == EntryTypeLink {
+		// symlinks are listed as "name -> target"
+		if i := strings.Index(e.Name, " -> "); i != -1 {
+			e.Target = e.Name[i+4:]
+			e.Name = e.Name[:i]
+		}
+	}
 	return e, nil
 }
 
-// NameList issues an NLST FTP command.
+// newListScanner returns a line scanner for a directory listing, which
+// enforces MaxListLineSize.
+func (c *ServerConn) newListScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	max := c.MaxListLineSize
+	if max <= 0 {
+		max = int(^uint(0) >> 1)
+	}
+	size := 4096
+	if max < size {
+		size = max
+	}
+	scanner.Buffer(make([]byte, 0, size), max)
+	return scanner
+}
+
+// listScanErr returns the error of a listing scanner, reporting lines which
+// exceed MaxListLineSize as ErrResponseTooLarge.
+func listScanErr(scanner *bufio.Scanner) error {
+	err := scanner.Err()
+	if err == bufio.ErrTooLong {
+		err = ErrResponseTooLarge
+	}
+	return err
+}
+
+// parseError reports a listing line which could not be parsed to the
+// OnParseError callback, if any.
+func (c *ServerConn) parseError(line string, err error) {
+	if c.OnParseError != nil {
+		c.OnParseError(line, err)
+	}
+}
+
+// NameList issues an NLST FTP command.
 func (c *ServerConn) NameList(path string) (entries []string, err error) {
-	path = c.toServerEncoding(path)
+	err = c.retryList(func() (err error) {
+		entries, err = c.nameList(path)
+		return
+	})
+	return entries, opError("NLST", path, err)
+}
+
+// nameList issues a NLST FTP command.
+func (c *ServerConn) nameList(path string) (entries []string, err error) {
+	path = c.listArg(c.toServerEncoding(path))
 	conn, err := c.cmdDataConnFrom(0, "NLST %s", path)
 	if err != nil {
 		return
 	}
 
 	r := &response{conn, c}
-	defer r.Close()
+	defer func() {
+		if cerr := r.Close(); err == nil && cerr != nil {
+			entries, err = nil, cerr
+		}
+	}()
 
-	scanner := bufio.NewScanner(r)
+	scanner := c.newListScanner(r)
 	for scanner.Scan() {
-		entries = append(entries, c.fromServerEncoding(scanner.Text()))
+		entries = append(entries, c.fromServerEncoding(decodePathname(scanner.Text())))
 	}
-	if err = scanner.Err(); err != nil {
+	if err = listScanErr(scanner); err != nil {
 		return entries, err
 	}
 	return
 }
 
-// List issues a LIST FTP command.
-func (c *ServerConn) List(path string) (entries []*Entry, err error) {
-	path = c.toServerEncoding(path)
+// List issues a LIST FTP command. The options are applied to the listing
+// once it has been received.
+func (c *ServerConn) List(path string, opts ...ListOption) (entries []*Entry, err error) {
+	err = c.retryList(func() (err error) {
+		entries, err = c.list(path)
+		return
+	})
+	if err != nil {
+		return nil, opError("LIST", path, err)
+	}
+	return c.applyListOptions(path, entries, opts)
+}
+
+// listArg returns the argument of LIST or NLST for path, which is not taken
+// for options with the ListOptionArgs quirk.
+func (c *ServerConn) listArg(path string) string {
+	if c.ListOptionArgs && strings.HasPrefix(path, "-") {
+		return "./" + path
+	}
+	return path
+}
+
+// list issues a LIST FTP command and parses the listing.
+func (c *ServerConn) list(path string) (entries []*Entry, err error) {
+	path = c.listArg(c.toServerEncoding(path))
 	conn, err := c.cmdDataConnFrom(0, "LIST %s", path)
 	if err != nil {
 		return
 	}
 
 	r := &response{conn, c}
-	defer r.Close()
+	defer func() {
+		if cerr := r.Close(); err == nil && cerr != nil {
+			entries, err = nil, cerr
+		}
+	}()
 
-	bio := bufio.NewReader(r)
-	for {
-		line, e := bio.ReadString('\n')
-		if e == io.EOF {
-			break
-		} else if e != nil {
-			return nil, e
+	scanner := c.newListScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "total ") {
+			// not an entry, nothing worth reporting
+			continue
 		}
 		entry, err := c.parseListLine(line)
-		if err == nil {
-			entries = append(entries, entry)
+		if err != nil {
+			c.parseError(line, err)
+			continue
 		}
+		entries = append(entries, entry)
+	}
+	if err = listScanErr(scanner); err != nil {
+		return nil, err
 	}
 	return
 }
@@ -509,66 +1434,152 @@ func ParseMListTime(sTime string) (t time.Time, err error) {
 
 // parseMListLine parses the (hopefully) standard format returned by the MLS(D|T) FTP command.
 func (c *ServerConn) parseMListLine(line string) (e EntryEx, err error) {
-	line = strings.Trim(line, " \r\n\t")
-	fields := strings.Split(line, ";")
+	// the name is kept as is, including leading and trailing blanks
+	line = strings.TrimRight(line, "\r\n")
+
+	// the facts are separated from the name by the first space, and may be
+	// empty
+	sep := strings.Index(line, " ")
+	if sep == -1 {
+		err = fmt.Errorf("invalid filename %s", line)
+		return
+	}
 
+	e.raw = line
 	e.Facts = make(map[string]string)
-	for idx, item := range fields {
-		if idx == len(fields)-1 {
-			// the last item should be the filename
-			// it is always preceded by a space
-			if strings.HasPrefix(item, " ") {
-				name := c.fromServerEncoding(item[1:])
-				e.SetName(name)
-			} else {
-				err = fmt.Errorf("invalid filename %s", item)
-				return
-			}
-		} else {
-			// other items are facts, in the form "key=value"
-			factKV := strings.Split(item, "=")
-			if len(factKV) == 2 {
-				e.Facts[strings.ToLower(factKV[0])] = factKV[1]
-			}
+	for _, item := range strings.Split(line[:sep], ";") {
+		// facts are in the form "key=value"
+		factKV := strings.SplitN(item, "=", 2)
+		if len(factKV) == 2 {
+			e.Facts[strings.ToLower(factKV[0])] = factKV[1]
 		}
 	}
+	e.SetName(c.fromServerEncoding(decodePathname(line[sep+1:])))
 	return
 }
 
+// decodePathname decodes the LF characters of a path name, sent as NUL as
+// described in RFC 3659 section 2.2.
+func decodePathname(name string) string {
+	return strings.Replace(name, "\x00", "\n", -1)
+}
+
+// fieldsRest returns what follows the first n blank separated fields of line
+// and the single space after them, leaving other blanks untouched.
+func fieldsRest(line string, n int) string {
+	i := 0
+	for f := 0; f < n; f++ {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+	}
+	if i < len(line) {
+		i++
+	}
+	return line[i:]
+}
+
 // MList issues an MLSD command, which lists a directory in a standard format
-func (c *ServerConn) MList(path string) (entries []EntryEx, err error) {
-	path = c.toServerEncoding(path)
-	conn, err := c.cmdDataConnFrom(0, "MLSD %s", path)
+//
+// If MListChangeDir is set, or if the server rejects MLSD with a path
+// argument, MList changes to the directory, issues a bare MLSD and changes
+// back.
+func (c *ServerConn) MList(path string, opts ...ListOption) (entries []EntryEx, err error) {
+	err = c.retryList(func() (err error) {
+		entries, err = c.mlist(path)
+		return
+	})
+	if err != nil {
+		return nil, opError("MLSD", path, err)
+	}
+	return applyMListOptions(entries, opts)
+}
+
+// retryList runs a listing attempt according to ListRetry.
+func (c *ServerConn) retryList(attempt func() error) error {
+	return c.ListRetry.doIf(isTransient, func(int) error {
+		return attempt()
+	})
+}
+
+// mlist issues the MLSD FTP command, or its fallback, once.
+func (c *ServerConn) mlist(path string) (entries []EntryEx, err error) {
+	if path == "" {
+		return c.mlsd("MLSD")
+	}
+	if c.MListChangeDir {
+		return c.mlistInDir(path)
+	}
+
+	entries, err = c.mlsd("MLSD %s", c.toServerEncoding(path))
+	if e, ok := replyError(err); ok {
+		switch e.Code {
+		case StatusBadCommand, StatusBadArguments, StatusNotImplementedParameter:
+			return c.mlistInDir(path)
+		}
+	}
+	return
+}
+
+// mlistInDir lists the specified directory with a bare MLSD after changing
+// to it, then changes back to the current directory.
+func (c *ServerConn) mlistInDir(path string) (entries []EntryEx, err error) {
+	cwd, err := c.CurrentDir()
 	if err != nil {
 		return
 	}
+	if err = c.ChangeDir(path); err != nil {
+		return
+	}
 
-	r := &response{conn, c}
-	defer r.Close()
+	entries, err = c.mlsd("MLSD")
+	if cdErr := c.ChangeDir(cwd); err == nil {
+		err = cdErr
+	}
+	return
+}
 
-	bio := bufio.NewReader(r)
-	for {
-		line, e := bio.ReadString('\n')
-		if e == io.EOF {
-			break
-		} else if e != nil {
-			return nil, e
+// mlsd issues the specified MLSD command and parses the listing.
+func (c *ServerConn) mlsd(format string, args ...interface{}) (entries []EntryEx, err error) {
+	conn, err := c.cmdDataConnFrom(0, format, args...)
+	if err != nil {
+		return
+	}
+
+	r := &response{conn, c}
+	defer func() {
+		if cerr := r.Close(); err == nil && cerr != nil {
+			entries, err = nil, cerr
 		}
+	}()
+
+	scanner := c.newListScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
 		entry, err := c.parseMListLine(line)
-		if err == nil && (entry.Name() != "." && entry.Name() != ".." || c.ListDotDirs) {
+		if err != nil {
+			c.parseError(line, err)
+			continue
+		}
+		if entry.Name() != "." && entry.Name() != ".." || c.ListDotDirs {
 			entries = append(entries, entry)
 		}
 	}
+	if err = listScanErr(scanner); err != nil {
+		return nil, err
+	}
 	return
 }
 
 // MInfo issues an MLST command, which returns info about the specified directory entry
 // in a standard format
 func (c *ServerConn) MInfo(path string) (entry EntryEx, err error) {
-	path = c.toServerEncoding(path)
-	_, resp, err := c.cmd(StatusRequestedFileActionOK, "MLST %s", path)
+	_, resp, err := c.cmd(StatusRequestedFileActionOK, "MLST %s", c.toServerEncoding(path))
 	if err != nil {
-		return
+		return entry, opError("MLST", path, err)
 	}
 	lines := strings.Split(resp, "\n")
 	// RFC3659 section 7.2. (control-response) states that the response has 3 lines,
@@ -582,12 +1593,156 @@ func (c *ServerConn) MInfo(path string) (entry EntryEx, err error) {
 	return
 }
 
+// SetCreateTime issues a MFCT FTP command to set the creation time of the
+// specified file on the remote FTP server.
+// MFCT is described in draft-somers-ftp-mfxx
+func (c *ServerConn) SetCreateTime(path string, t time.Time) error {
+	if _, mfctSupported := c.feature("MFCT"); !mfctSupported {
+		return errors.New("MFCT not supported by server")
+	}
+	_, _, err := c.cmd(StatusFile, "MFCT %s %s", t.UTC().Format(TimeLayoutMlsx), c.toServerEncoding(path))
+	return opError("MFCT", path, err)
+}
+
+// HashAlgorithms returns the hash algorithms advertised by the HASH feature,
+// the currently selected one first.
+// HASH is described in draft-bryan-ftpext-hash
+func (c *ServerConn) HashAlgorithms() []string {
+	desc, hashSupported := c.feature("HASH")
+	if !hashSupported {
+		return nil
+	}
+
+	var algos []string
+	for _, algo := range strings.Split(desc, ";") {
+		if strings.HasSuffix(algo, "*") {
+			algos = append([]string{strings.TrimSuffix(algo, "*")}, algos...)
+		} else if algo != "" {
+			algos = append(algos, algo)
+		}
+	}
+	return algos
+}
+
+// SetHashAlgorithm issues an OPTS HASH FTP command to select the algorithm
+// used by subsequent HASH commands.
+func (c *ServerConn) SetHashAlgorithm(algo string) error {
+	if _, hashSupported := c.feature("HASH"); !hashSupported {
+		return opError("OPTS", "", errors.New("HASH not supported by server"))
+	}
+	_, _, err := c.cmd(StatusCommandOK, "OPTS HASH %s", algo)
+	if err != nil {
+		return opError("OPTS", "", err)
+	}
+
+	// keep the advertised list in sync with the new selection
+	algos := c.HashAlgorithms()
+	for i, a := range algos {
+		if strings.EqualFold(a, algo) {
+			algos[i] = a + "*"
+		}
+	}
+	c.setFeature("HASH", strings.Join(algos, ";"))
+	return nil
+}
+
+// Hash issues a HASH FTP command, which returns the hex encoded digest of the
+// specified file computed by the remote FTP server with the currently
+// selected algorithm.
+func (c *ServerConn) Hash(path string) (string, error) {
+	if _, hashSupported := c.feature("HASH"); !hashSupported {
+		return "", errors.New("HASH not supported by server")
+	}
+	_, msg, err := c.cmd(StatusFile, "HASH %s", c.toServerEncoding(path))
+	if err != nil {
+		return "", opError("HASH", path, err)
+	}
+
+	// HASH response format : 213 <algorithm> <start>-<end> <digest> <filename>
+	fields := strings.SplitN(msg, " ", 4)
+	if len(fields) < 3 {
+		return "", fmt.Errorf("unexpected HASH response %s", msg)
+	}
+	return strings.ToLower(fields[2]), nil
+}
+
+// Exists reports whether the specified file exists on the remote FTP server.
+//
+// An MLST command is used if the server supports it. Otherwise, as a last
+// resort for minimal servers, an NLST command is issued on the exact path: a
+// 450 or 550 reply, or an empty listing, means that the file does not exist.
+// With NLST, an empty directory can not be told apart from a missing file.
+func (c *ServerConn) Exists(path string) (bool, error) {
+	if _, mlstSupported := c.feature("MLST"); mlstSupported {
+		_, err := c.MInfo(path)
+		if isNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	entries, err := c.NameList(path)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// some servers send the error message of ls on the data connection
+	if len(entries) == 1 {
+		msg := strings.ToLower(entries[0])
+		if strings.Contains(msg, "no such file") || strings.Contains(msg, "not found") {
+			return false, nil
+		}
+	}
+	return len(entries) > 0, nil
+}
+
+// isNotFound reports whether err is a reply used by servers for a missing
+// file.
+func isNotFound(err error) bool {
+	e, ok := replyError(err)
+	return ok && (e.Code == StatusFileActionIgnored || e.Code == StatusFileUnavailable)
+}
+
+// FileSize issues a SIZE FTP command, which returns the size of the
+// specified file.
+// SIZE is described in RFC 3659
+func (c *ServerConn) FileSize(path string) (int64, error) {
+	if c.SizeBinaryOnly {
+		if err := c.setType("I"); err != nil {
+			return 0, opError("SIZE", path, err)
+		}
+	}
+	_, msg, err := c.cmd(StatusFile, "SIZE %s", c.toServerEncoding(path))
+	if err != nil {
+		return 0, opError("SIZE", path, err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	return size, opError("SIZE", path, err)
+}
+
+// ModTime issues a MDTM FTP command, which returns the last modification time
+// of the specified file.
+// MDTM is described in RFC 3659
+func (c *ServerConn) ModTime(path string) (time.Time, error) {
+	_, msg, err := c.cmd(StatusFile, "MDTM %s", c.toServerEncoding(path))
+	if err != nil {
+		return time.Time{}, opError("MDTM", path, err)
+	}
+	t, err := ParseMListTime(strings.TrimSpace(msg))
+	return t, opError("MDTM", path, err)
+}
+
 // ChangeDir issues a CWD FTP command, which changes the current directory to
 // the specified path.
 func (c *ServerConn) ChangeDir(path string) error {
-	path = c.toServerEncoding(path)
-	_, _, err := c.cmd(StatusRequestedFileActionOK, "CWD %s", path)
-	return err
+	_, _, err := c.cmd(StatusRequestedFileActionOK, "CWD %s", c.toServerEncoding(path))
+	if err == nil {
+		c.trackDir()
+	}
+	return opError("CWD", path, err)
 }
 
 // ChangeDirToParent issues a CDUP FTP command, which changes the current
@@ -595,7 +1750,10 @@ func (c *ServerConn) ChangeDir(path string) error {
 // with a path set to "..".
 func (c *ServerConn) ChangeDirToParent() error {
 	_, _, err := c.cmd(StatusRequestedFileActionOK, "CDUP")
-	return err
+	if err == nil {
+		c.trackDir()
+	}
+	return opError("CDUP", "", err)
 }
 
 // CurrentDir issues a PWD FTP command, which Returns the path of the current
@@ -603,17 +1761,55 @@ func (c *ServerConn) ChangeDirToParent() error {
 func (c *ServerConn) CurrentDir() (string, error) {
 	_, msg, err := c.cmd(StatusPathCreated, "PWD")
 	if err != nil {
-		return "", err
+		return "", opError("PWD", "", err)
 	}
 
+	dir, err := parsePathReply(msg)
+	if err != nil {
+		return "", opError("PWD", "", errors.New("unsupported PWD response format"))
+	}
+	return c.fromServerEncoding(dir), nil
+}
+
+// parsePathReply extracts the quoted path of a 257 reply, in which the
+// quotes of the path are doubled.
+func parsePathReply(msg string) (string, error) {
 	start := strings.Index(msg, "\"")
-	end := strings.LastIndex(msg, "\"")
+	if start == -1 {
+		return "", fmt.Errorf("no path in reply %s", msg)
+	}
 
-	if start == -1 || end == -1 {
-		return "", errors.New("unsupported PWD response format")
+	var dir strings.Builder
+	for i := start + 1; i < len(msg); i++ {
+		if msg[i] != '"' {
+			dir.WriteByte(msg[i])
+			continue
+		}
+		if i+1 < len(msg) && msg[i+1] == '"' {
+			dir.WriteByte('"')
+			i++
+			continue
+		}
+		return dir.String(), nil
 	}
+	return "", fmt.Errorf("no path in reply %s", msg)
+}
 
-	return c.fromServerEncoding(msg[start+1 : end]), nil
+// LastReply returns the last reply received on the control connection, such
+// as the confirmation of the last Delete, MakeDir or Rename.
+func (c *ServerConn) LastReply() Reply {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.lastReply
+}
+
+// recordReply records a reply to the command of the history entry h, if
+// any, and for LastReply.
+func (c *ServerConn) recordReply(h *HistoryEntry, code int, msg string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	h.reply(code)
+	c.lastReply = Reply{code, msg}
 }
 
 // Retr issues a RETR FTP command to fetch the specified file from the remote
@@ -629,16 +1825,50 @@ func (c *ServerConn) Retr(path string) (io.ReadCloser, error) {
 //
 // The returned ReadCloser must be closed to cleanup the FTP data connection.
 func (c *ServerConn) RetrFrom(path string, offset uint64) (io.ReadCloser, error) {
-	path = c.toServerEncoding(path)
-	conn, err := c.cmdDataConnFrom(offset, "RETR %s", path)
+	return c.retr(c.typeFor(path, offset), path, offset)
+}
+
+// retr issues a RETR FTP command like RetrFrom, with the transfer type t.
+func (c *ServerConn) retr(t, path string, offset uint64) (io.ReadCloser, error) {
+	conn, err := c.cmdTransferFrom(t, offset, "RETR %s", c.toServerEncoding(path))
 	if err != nil {
-		return nil, err
+		return nil, opError("RETR", path, err)
 	}
 
 	r := &response{conn, c}
 	return r, nil
 }
 
+// RetrRange issues a RETR FTP command to fetch exactly length bytes of the
+// specified file, starting at offset. The range is requested with RANG if the
+// server supports it, otherwise with REST and the data connection is closed
+// once the range has been read.
+//
+// The returned ReadCloser must be closed to cleanup the FTP data connection.
+func (c *ServerConn) RetrRange(path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.New("invalid range")
+	}
+	var restart string
+	if _, rangSupported := c.feature("RANG"); rangSupported && length > 0 {
+		// RANG is described in draft-bryan-ftp-range, the end byte is inclusive
+		restart = fmt.Sprintf("RANG %d %d", offset, offset+length-1)
+	} else if offset != 0 {
+		restart = fmt.Sprintf("REST %d", offset)
+	}
+
+	conn, err := c.cmdDataConnRestart("I", restart, "RETR %s", c.toServerEncoding(path))
+	if err != nil {
+		return nil, opError("RETR", path, err)
+	}
+
+	r := &rangeResponse{
+		response: response{conn, c},
+		r:        io.LimitReader(conn, length),
+	}
+	return r, nil
+}
+
 // Stor issues a STOR FTP command to store a file to the remote FTP server.
 // Stor creates the specified file with the content of the io.Reader.
 //
@@ -653,59 +1883,223 @@ func (c *ServerConn) Stor(path string, r io.Reader) error {
 //
 // Hint: io.Pipe() can be used if an io.Writer is required.
 func (c *ServerConn) StorFrom(path string, r io.Reader, offset uint64) error {
-	path = c.toServerEncoding(path)
+	return c.store(offset, "STOR", path, r)
+}
+
+// Append issues a APPE FTP command to store a file to the remote FTP server.
+// If a file already exists with the given path, the content of the io.Reader
+// is appended to it. Otherwise, a new file is created with that content.
+//
+// Hint: io.Pipe() can be used if an io.Writer is required.
+func (c *ServerConn) Append(path string, r io.Reader) error {
+	return c.store(0, "APPE", path, r)
+}
 
-	conn, err := c.cmdDataConnFrom(offset, "STOR %s", path)
+// store uploads the content of the io.Reader with the specified STOR-like
+// command.
+func (c *ServerConn) store(offset uint64, command, path string, r io.Reader) error {
+	conn, err := c.cmdTransferFrom(c.typeFor(path, offset), offset, "%s %s", command, c.toServerEncoding(path))
 	if err != nil {
-		return err
+		return opError(command, path, err)
 	}
 
 	_, err = io.Copy(conn, r)
 	conn.Close()
+
+	// the final reply is read even if the copy failed, to end the transfer
+	err2 := c.readTransferComplete()
+	if err == nil {
+		err = err2
+	}
+	return opError(command, path, err)
+}
+
+// readTransferComplete reads the reply sent by the server at the end of a
+// transfer.
+func (c *ServerConn) readTransferComplete() error {
+	code, msg, err := c.transferReply()
 	if err != nil {
 		return err
 	}
+	if code != StatusClosingDataConnection && (code != StatusRequestedFileActionOK || !c.TransferComplete250) {
+		return &textproto.Error{Code: code, Msg: msg}
+	}
+	return nil
+}
 
-	_, _, err = c.conn.ReadCodeLine(StatusClosingDataConnection)
-	return err
+// transferReply reads the reply sent by the server at the end of a transfer,
+// which ends the transfer in progress.
+func (c *ServerConn) transferReply() (int, string, error) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	c.transferring = false
+	code, msg, err := c.conn.ReadResponse(-1)
+	c.recordReply(c.history.transfer, code, msg)
+	return code, msg, err
 }
 
 // Rename renames a file on the remote FTP server.
+// With AutoReconnect, RNFR and RNTO are issued again once reconnected if the
+// control connection was broken before RNTO was sent.
 func (c *ServerConn) Rename(from, to string) error {
-	from = c.toServerEncoding(from)
-	to = c.toServerEncoding(to)
+	sent, err := c.rename(from, to)
+	if c.canReconnect(err) && c.reconnect() == nil && !sent {
+		_, err = c.rename(from, to)
+	}
+	return err
+}
 
-	_, _, err := c.cmd(StatusRequestFilePending, "RNFR %s", from)
-	if err != nil {
-		return err
+// rename issues RNFR and RNTO without releasing the control connection in
+// between, and reports whether RNTO was sent.
+func (c *ServerConn) rename(from, to string) (bool, error) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if c.transferring {
+		return false, opError("RNFR", from, ErrTransferInProgress)
 	}
 
-	_, _, err = c.cmd(StatusRequestedFileActionOK, "RNTO %s", to)
-	return err
+	_, _, err := c.exchange(StatusRequestFilePending, "RNFR %s", c.toServerEncoding(from))
+	if err != nil {
+		return false, opError("RNFR", from, err)
+	}
+	h, err := c.send("RNTO %s", c.toServerEncoding(to))
+	if err != nil {
+		return false, opError("RNTO", to, err)
+	}
+	_, _, err = c.receive(StatusRequestedFileActionOK, h)
+	return true, opError("RNTO", to, err)
 }
 
 // Delete issues a DELE FTP command to delete the specified file from the
 // remote FTP server.
 func (c *ServerConn) Delete(path string) error {
-	path = c.toServerEncoding(path)
-	_, _, err := c.cmd(StatusRequestedFileActionOK, "DELE %s", path)
-	return err
+	_, _, err := c.cmd(StatusRequestedFileActionOK, "DELE %s", c.toServerEncoding(path))
+	return opError("DELE", path, err)
 }
 
 // MakeDir issues a MKD FTP command to create the specified directory on the
 // remote FTP server.
 func (c *ServerConn) MakeDir(path string) error {
-	path = c.toServerEncoding(path)
-	_, _, err := c.cmd(StatusPathCreated, "MKD %s", path)
-	return err
+	_, _, err := c.cmd(StatusPathCreated, "MKD %s", c.toServerEncoding(path))
+	return opError("MKD", path, err)
+}
+
+// MakeDirPath creates the specified directory like MakeDir and returns its
+// path as reported by the server, which may have rewritten it (e.g. made it
+// absolute).
+func (c *ServerConn) MakeDirPath(path string) (string, error) {
+	_, msg, err := c.cmd(StatusPathCreated, "MKD %s", c.toServerEncoding(path))
+	if err != nil {
+		return "", opError("MKD", path, err)
+	}
+
+	created, err := parsePathReply(msg)
+	if err != nil {
+		return "", opError("MKD", path, err)
+	}
+	return c.fromServerEncoding(created), nil
 }
 
 // RemoveDir issues a RMD FTP command to remove the specified directory from
 // the remote FTP server.
 func (c *ServerConn) RemoveDir(path string) error {
-	path = c.toServerEncoding(path)
-	_, _, err := c.cmd(StatusRequestedFileActionOK, "RMD %s", path)
-	return err
+	_, _, err := c.cmd(StatusRequestedFileActionOK, "RMD %s", c.toServerEncoding(path))
+	return opError("RMD", path, err)
+}
+
+// Symlink issues a SITE SYMLINK FTP command to create a symbolic link named
+// link pointing to target on the remote FTP server. Servers which do not
+// understand SITE SYMLINK are retried with SITE LN.
+func (c *ServerConn) Symlink(target, link string) error {
+	serverTarget := c.toServerEncoding(target)
+	serverLink := c.toServerEncoding(link)
+
+	_, _, err := c.cmd(StatusCommandOK, "SITE SYMLINK %s %s", serverTarget, serverLink)
+	if e, ok := replyError(err); ok {
+		switch e.Code {
+		case StatusBadCommand, StatusBadArguments, StatusNotImplemented, StatusNotImplementedParameter:
+			_, _, err = c.cmd(StatusCommandOK, "SITE LN %s %s", serverTarget, serverLink)
+		}
+	}
+	return opError("SITE SYMLINK", link, err)
+}
+
+// Chown issues a SITE CHOWN FTP command to change the owner of the specified
+// file on the remote FTP server.
+func (c *ServerConn) Chown(path, owner string) error {
+	_, _, err := c.cmd(StatusCommandOK, "SITE CHOWN %s %s", owner, c.toServerEncoding(path))
+	return opError("SITE CHOWN", path, err)
+}
+
+// Chgrp issues a SITE CHGRP FTP command to change the group of the specified
+// file on the remote FTP server.
+func (c *ServerConn) Chgrp(path, group string) error {
+	_, _, err := c.cmd(StatusCommandOK, "SITE CHGRP %s %s", group, c.toServerEncoding(path))
+	return opError("SITE CHGRP", path, err)
+}
+
+// Umask issues a SITE UMASK FTP command without argument, which returns the
+// umask applied to the files created during the session.
+func (c *ServerConn) Umask() (os.FileMode, error) {
+	_, msg, err := c.cmd(StatusCommandOK, "SITE UMASK")
+	if err != nil {
+		return 0, opError("SITE UMASK", "", err)
+	}
+
+	// the reply wording varies, e.g. "Current UMASK is 022"; use the last
+	// octal number of the message
+	fields := strings.Fields(msg)
+	for i := len(fields) - 1; i >= 0; i-- {
+		mask, err := strconv.ParseUint(strings.TrimRight(fields[i], "."), 8, 32)
+		if err == nil {
+			return os.FileMode(mask), nil
+		}
+	}
+	return 0, opError("SITE UMASK", "", fmt.Errorf("unexpected response %s", msg))
+}
+
+// SetUmask issues a SITE UMASK FTP command to set the umask applied to the
+// files created by subsequent commands.
+func (c *ServerConn) SetUmask(mask os.FileMode) error {
+	_, _, err := c.cmd(StatusCommandOK, "SITE UMASK %03o", mask.Perm())
+	return opError("SITE UMASK", "", err)
+}
+
+// SetIdleTimeout issues a SITE IDLE FTP command to ask the remote FTP server
+// to keep the idle control connection open for the specified duration. The
+// server may grant a different value, which is then returned by IdleTimeout.
+// The duration must not be negative.
+func (c *ServerConn) SetIdleTimeout(d time.Duration) error {
+	if d < 0 {
+		return opError("SITE IDLE", "", errors.New("negative idle timeout"))
+	}
+	seconds := int(d / time.Second)
+	_, msg, err := c.cmd(StatusCommandOK, "SITE IDLE %d", seconds)
+	if err != nil {
+		return opError("SITE IDLE", "", err)
+	}
+
+	// e.g. "Maximum idle time set to 600 seconds"
+	timeout := time.Duration(seconds) * time.Second
+	for _, field := range strings.Fields(msg) {
+		if granted, err := strconv.Atoi(field); err == nil && granted >= 0 {
+			timeout = time.Duration(granted) * time.Second
+			break
+		}
+	}
+	c.stateMu.Lock()
+	c.idleTimeout = timeout
+	c.stateMu.Unlock()
+	return nil
+}
+
+// IdleTimeout returns the idle timeout negotiated by SetIdleTimeout, or zero
+// if none was negotiated. Callers should issue NoOp more often than that to
+// keep the session alive between batches.
+func (c *ServerConn) IdleTimeout() time.Duration {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.idleTimeout
 }
 
 // NoOp issues a NOOP FTP command.
@@ -713,22 +2107,106 @@ func (c *ServerConn) RemoveDir(path string) error {
 // close the otherwise idle connection.
 func (c *ServerConn) NoOp() error {
 	_, _, err := c.cmd(StatusCommandOK, "NOOP")
-	return err
+	return opError("NOOP", "", err)
 }
 
-// Logout issues a REIN FTP command to logout the current user.
+// Logout issues a REIN FTP command to logout the current user. As the
+// session is reset, the features of the server are queried again.
 func (c *ServerConn) Logout() error {
 	_, _, err := c.cmd(StatusReady, "REIN") // from dsluis/goftp
-	return err
+	if err != nil {
+		return opError("REIN", "", err)
+	}
+	c.cmdMu.Lock()
+	c.transferType = ""
+	c.epsvAll = false
+	c.cmdMu.Unlock()
+	c.session.auth, c.session.dir = nil, ""
+	return c.refreshFeatures()
+}
+
+// Host issues a HOST FTP command to select the virtual host the session is
+// for, before Login. As the server may differ per host, its features are
+// queried again.
+// HOST is described in RFC 7151
+func (c *ServerConn) Host(name string) error {
+	_, _, err := c.cmd(StatusReady, "HOST %s", name)
+	if err != nil {
+		return opError("HOST", "", err)
+	}
+	return c.refreshFeatures()
 }
 
 // Quit issues a QUIT FTP command to properly close the connection from the
 // remote FTP server.
 func (c *ServerConn) Quit() error {
+	c.dataMu.Lock()
+	c.session.closed = true
+	c.dataMu.Unlock()
 	c.conn.Cmd("QUIT")
 	return c.conn.Close()
 }
 
+// Close closes the control connection and the open data connections right
+// away, without issuing QUIT, so that the operations in progress in other
+// goroutines fail instead of blocking.
+func (c *ServerConn) Close() error {
+	c.dataMu.Lock()
+	c.session.closed = true
+	conns := c.dataConns
+	c.dataConns = nil
+	c.dataMu.Unlock()
+
+	for d := range conns {
+		d.Conn.Close()
+	}
+	return c.conn.Close()
+}
+
+// dataConn is a data connection registered for Close.
+type dataConn struct {
+	net.Conn
+	c *ServerConn
+}
+
+// trackDataConn registers a data connection, until it is closed.
+func (c *ServerConn) trackDataConn(conn net.Conn) net.Conn {
+	d := &dataConn{conn, c}
+	c.dataMu.Lock()
+	if c.dataConns == nil {
+		c.dataConns = make(map[*dataConn]struct{})
+	}
+	c.dataConns[d] = struct{}{}
+	c.dataMu.Unlock()
+	return d
+}
+
+// Read implements the io.Reader interface.
+func (d *dataConn) Read(buf []byte) (int, error) {
+	n, err := d.Conn.Read(buf)
+	if d.c.transferred != nil {
+		atomic.AddInt64(d.c.transferred, int64(n))
+	}
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (d *dataConn) Write(buf []byte) (int, error) {
+	n, err := d.Conn.Write(buf)
+	if d.c.transferred != nil {
+		atomic.AddInt64(d.c.transferred, int64(n))
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (d *dataConn) Close() error {
+	d.c.dataMu.Lock()
+	delete(d.c.dataConns, d)
+	d.c.dataMu.Unlock()
+	return d.Conn.Close()
+}
+
 // The following functions implement the FileSystem interface
 
 // ReadDir reads the directory named by dirname and returns a
@@ -753,6 +2231,67 @@ func (c *ServerConn) Lstat(name string) (entry os.FileInfo, err error) {
 	return
 }
 
+// maxLinkHops is the number of symbolic links StatFollow follows before
+// giving up.
+const maxLinkHops = 16
+
+// ResolveLink returns the target of the symbolic link with the specified
+// name, as found in the LIST output of the remote FTP server. A relative
+// target is resolved against the directory containing the link.
+func (c *ServerConn) ResolveLink(name string) (string, error) {
+	target, isLink, err := c.linkTarget(name)
+	if err != nil {
+		return "", err
+	}
+	if !isLink {
+		return "", fmt.Errorf("%s is not a symbolic link", name)
+	}
+	return target, nil
+}
+
+// StatFollow returns a FileInfo describing the named file. Unlike Lstat, if
+// the file is a symbolic link, StatFollow follows it and describes its
+// target.
+func (c *ServerConn) StatFollow(name string) (os.FileInfo, error) {
+	for i := 0; i < maxLinkHops; i++ {
+		target, isLink, err := c.linkTarget(name)
+		if err != nil || !isLink {
+			return c.Lstat(name)
+		}
+		name = target
+	}
+	return nil, fmt.Errorf("too many levels of symbolic links at %s", name)
+}
+
+// sameName reports whether a listed name is the requested one, ignoring
+// case with the CaseInsensitiveNames quirk.
+func (c *ServerConn) sameName(listed, name string) bool {
+	if c.CaseInsensitiveNames {
+		return strings.EqualFold(listed, name)
+	}
+	return listed == name
+}
+
+// linkTarget lists the named file and returns its resolved target if it is
+// a symbolic link.
+func (c *ServerConn) linkTarget(name string) (target string, isLink bool, err error) {
+	entries, err := c.List(name)
+	if err != nil {
+		return
+	}
+	// listing a directory holding a single link must not be mistaken for
+	// listing the link itself
+	if len(entries) != 1 || !c.sameName(entries[0].Name, path.Base(name)) || entries[0].Type != EntryTypeLink || entries[0].Target == "" {
+		return
+	}
+
+	target = entries[0].Target
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(name), target)
+	}
+	return target, true, nil
+}
+
 // Join joins any number of path elements into a single path, adding a
 // separator if necessary. The result is Cleaned; in particular, all
 // empty strings are ignored.
@@ -768,12 +2307,67 @@ func (r *response) Read(buf []byte) (int, error) {
 	return n, err
 }
 
+// limitReader limits the number of bytes read on the control connection
+// between two calls to reset.
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+// reset starts counting a new reply, max <= 0 disables the limit.
+func (l *limitReader) reset(max int64) {
+	l.n = 0
+	l.max = max
+}
+
+// Read implements the io.Reader interface, failing with ErrResponseTooLarge
+// once the limit is exceeded.
+func (l *limitReader) Read(buf []byte) (int, error) {
+	if l.max > 0 && l.n >= l.max {
+		return 0, ErrResponseTooLarge
+	}
+	n, err := l.r.Read(buf)
+	l.n += int64(n)
+	return n, err
+}
+
 // Close implements the io.Closer interface on a FTP data connection.
 func (r *response) Close() error {
 	err := r.conn.Close()
-	_, _, err2 := r.c.conn.ReadResponse(StatusClosingDataConnection)
+	err2 := r.c.readTransferComplete()
 	if err2 != nil {
 		err = err2
 	}
 	return err
 }
+
+// rangeResponse represent a data-connection limited to a byte range.
+type rangeResponse struct {
+	response
+	r io.Reader
+}
+
+// Read implements the io.Reader interface, returning io.EOF at the end of
+// the range.
+func (r *rangeResponse) Read(buf []byte) (int, error) {
+	return r.r.Read(buf)
+}
+
+// Close implements the io.Closer interface on a ranged FTP data connection.
+// As the transfer may have been cut short, the server is allowed to report an
+// aborted transfer.
+func (r *rangeResponse) Close() error {
+	err := r.conn.Close()
+	code, msg, err2 := r.c.transferReply()
+	if err2 != nil {
+		return err2
+	}
+	switch {
+	case code == StatusClosingDataConnection, code == StatusTransfertAborted, code == StatusFileActionIgnored, code == StatusActionAborted:
+	case code == StatusRequestedFileActionOK && r.c.TransferComplete250:
+	default:
+		err = &textproto.Error{Code: code, Msg: msg}
+	}
+	return err
+}
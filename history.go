@@ -0,0 +1,113 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HistoryEntry records a command sent on the control connection, see
+// ServerConn.HistorySize.
+type HistoryEntry struct {
+	// when the command was sent
+	Time time.Time
+	// command line, with passwords masked
+	Command string
+	// code of the last reply received for the command, zero if none
+	Code int
+	// time elapsed until the last reply
+	Duration time.Duration
+	// bytes transferred on the data connection opened for the command
+	Bytes int64
+}
+
+// history is the ring buffer of the last commands.
+type history struct {
+	entries []*HistoryEntry
+	next    int
+	// entry of the data transfer in progress
+	transfer *HistoryEntry
+}
+
+// recordCommand adds a command to the history, if enabled, and returns its
+// entry.
+func (c *ServerConn) recordCommand(format string, args ...interface{}) *HistoryEntry {
+	size := c.HistorySize
+	if size <= 0 {
+		return nil
+	}
+
+	command := fmt.Sprintf(format, args...)
+	if verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb == "PASS" || verb == "ACCT" {
+		command = verb + " ****"
+	}
+	e := &HistoryEntry{Time: time.Now(), Command: command}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	h := &c.history
+	if len(h.entries) > size {
+		// the size was reduced, forget about the oldest entries
+		h.entries = append(h.entries[h.next:], h.entries[:h.next]...)
+		h.entries = h.entries[len(h.entries)-size:]
+		h.next = 0
+	}
+	if len(h.entries) < size {
+		h.entries = append(h.entries, e)
+	} else {
+		h.entries[h.next] = e
+	}
+	h.next = (h.next + 1) % size
+	return e
+}
+
+// reply records a reply to the command.
+func (e *HistoryEntry) reply(code int) {
+	if e != nil {
+		e.Code = code
+		e.Duration = time.Since(e.Time)
+	}
+}
+
+// History returns the last HistorySize commands sent on the control
+// connection, the oldest first.
+func (c *ServerConn) History() []HistoryEntry {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	h := &c.history
+	entries := make([]HistoryEntry, 0, len(h.entries))
+	for i := range h.entries {
+		e := h.entries[(h.next+i)%len(h.entries)]
+		entries = append(entries, HistoryEntry{
+			Time:     e.Time,
+			Command:  e.Command,
+			Code:     e.Code,
+			Duration: e.Duration,
+			Bytes:    atomic.LoadInt64(&e.Bytes),
+		})
+	}
+	return entries
+}
+
+// countingConn counts the bytes transferred on a data connection into a
+// history entry.
+type countingConn struct {
+	net.Conn
+	e *HistoryEntry
+}
+
+// Read implements the io.Reader interface.
+func (c *countingConn) Read(buf []byte) (int, error) {
+	n, err := c.Conn.Read(buf)
+	atomic.AddInt64(&c.e.Bytes, int64(n))
+	return n, err
+}
+
+// Write implements the io.Writer interface.
+func (c *countingConn) Write(buf []byte) (int, error) {
+	n, err := c.Conn.Write(buf)
+	atomic.AddInt64(&c.e.Bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,255 @@
+package ftp
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ListOption configures how List, ListEntries and MList post-process a
+// listing.
+type ListOption func(*listOptions)
+
+// listOptions holds the settings of the ListOptions.
+type listOptions struct {
+	exactTimes bool
+	pool       *Pool
+	workers    int
+
+	types   []EntryType
+	pattern string
+
+	sortBy  SortKey
+	reverse bool
+}
+
+// SortKey is the key by which ListSort sorts the entries.
+type SortKey int
+
+// The sort keys
+const (
+	sortNone   SortKey = iota
+	SortByName         // name, byte-wise
+	SortByTime         // modification time, oldest first
+	SortBySize         // size, smallest first
+)
+
+// ListSort sorts the entries by key, ties being sorted by name, in reverse
+// order if reverse is true.
+func ListSort(key SortKey, reverse bool) ListOption {
+	return func(o *listOptions) {
+		o.sortBy = key
+		o.reverse = reverse
+	}
+}
+
+// ListTypes keeps only the entries of the specified types.
+func ListTypes(types ...EntryType) ListOption {
+	return func(o *listOptions) {
+		o.types = types
+	}
+}
+
+// ListFilesOnly keeps only the files.
+func ListFilesOnly() ListOption {
+	return ListTypes(EntryTypeFile)
+}
+
+// ListDirsOnly keeps only the directories.
+func ListDirsOnly() ListOption {
+	return ListTypes(EntryTypeFolder)
+}
+
+// ListMatch keeps only the entries whose name matches the shell pattern, as
+// defined by path.Match.
+func ListMatch(pattern string) ListOption {
+	return func(o *listOptions) {
+		o.pattern = pattern
+	}
+}
+
+// keep reports whether the entry passes the filters.
+func (o *listOptions) keep(e *Entry) (bool, error) {
+	if o.types != nil {
+		found := false
+		for _, t := range o.types {
+			found = found || e.Type == t
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if o.pattern != "" {
+		return path.Match(o.pattern, e.Name)
+	}
+	return true, nil
+}
+
+// less reports whether a sorts before b.
+func (o *listOptions) less(a, b *Entry) bool {
+	if o.reverse {
+		a, b = b, a
+	}
+	switch o.sortBy {
+	case SortByTime:
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+	case SortBySize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	}
+	return a.Name < b.Name
+}
+
+// newListOptions applies the options.
+func newListOptions(opts []ListOption) *listOptions {
+	o := &listOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ListWithExactTimes replaces the timestamps of the files listed by LIST,
+// which the ls format only gives to the minute or to the day, with the exact time
+// returned by MDTM. With a nil pool, the MDTM commands are issued one by one
+// on the listing connection; otherwise they are spread over up to workers
+// connections of the pool, a relative directory being resolved with PWD
+// first. Files for which MDTM fails keep their timestamp. It has no effect on
+// MList, whose timestamps are exact already.
+func ListWithExactTimes(pool *Pool, workers int) ListOption {
+	return func(o *listOptions) {
+		o.exactTimes = true
+		o.pool = pool
+		o.workers = workers
+	}
+}
+
+// applyListOptions post-processes the entries listed for dir.
+func (c *ServerConn) applyListOptions(dir string, entries []*Entry, opts []ListOption) ([]*Entry, error) {
+	if len(opts) == 0 {
+		return entries, nil
+	}
+	o := newListOptions(opts)
+
+	kept := entries[:0]
+	for _, e := range entries {
+		ok, err := o.keep(e)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, e)
+		}
+	}
+	entries = kept
+
+	if o.exactTimes {
+		c.exactTimes(dir, entries, o.pool, o.workers)
+	}
+	if o.sortBy != sortNone {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return o.less(entries[i], entries[j])
+		})
+	}
+	return entries, nil
+}
+
+// applyMListOptions post-processes the entries listed by MList.
+func applyMListOptions(entries []EntryEx, opts []ListOption) ([]EntryEx, error) {
+	if len(opts) == 0 {
+		return entries, nil
+	}
+	o := newListOptions(opts)
+
+	kept := entries[:0]
+	for _, e := range entries {
+		ok, err := o.keep(e.Entry())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, e)
+		}
+	}
+	entries = kept
+
+	if o.sortBy != sortNone {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return o.less(entries[i].Entry(), entries[j].Entry())
+		})
+	}
+	return entries, nil
+}
+
+// exactTimes sets the time of the file entries with MDTM.
+func (c *ServerConn) exactTimes(dir string, entries []*Entry, pool *Pool, workers int) {
+	var files []*Entry
+	for _, e := range entries {
+		// the other times, such as those of MLSx, are exact already
+		if e.Type == EntryTypeFile && e.approxTime {
+			files = append(files, e)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	// the connections of the pool may be in another directory
+	if !path.IsAbs(dir) {
+		cwd, err := c.CurrentDir()
+		if err != nil {
+			return
+		}
+		dir = path.Join(cwd, dir)
+	}
+
+	if pool == nil {
+		for _, e := range files {
+			if t, err := c.ModTime(path.Join(dir, e.Name)); err == nil {
+				e.Time = t
+			}
+		}
+		return
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan *Entry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pc, err := pool.Acquire()
+			if err != nil {
+				// drain the work, the entries keep their time
+				for range work {
+				}
+				return
+			}
+			for e := range work {
+				var t time.Time
+				t, err = pc.ModTime(path.Join(dir, e.Name))
+				if err == nil {
+					e.Time = t
+				} else if _, ok := replyError(err); !ok {
+					// the connection is broken, the other workers go on
+					for range work {
+					}
+					break
+				}
+			}
+			pool.done(pc, err)
+		}()
+	}
+	for _, e := range files {
+		work <- e
+	}
+	close(work)
+	wg.Wait()
+}
@@ -0,0 +1,79 @@
+package ftp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListOptions(t *testing.T) {
+	c := &ServerConn{}
+	now := time.Now()
+	entries := []*Entry{
+		{Name: "b.xml", Type: EntryTypeFile, Size: 3, Time: now},
+		{Name: "dir", Type: EntryTypeFolder},
+		{Name: "a.xml", Type: EntryTypeFile, Size: 7, Time: now.Add(-time.Hour)},
+		{Name: "c.txt", Type: EntryTypeFile, Size: 1, Time: now},
+	}
+
+	got, err := c.applyListOptions("/", entries, []ListOption{
+		ListFilesOnly(),
+		ListMatch("*.xml"),
+		ListSort(SortBySize, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "a.xml" || got[1].Name != "b.xml" {
+		t.Errorf("applyListOptions() = %v, want [a.xml b.xml]", got)
+	}
+
+	if _, err = c.applyListOptions("/", got, []ListOption{ListMatch("[")}); err == nil {
+		t.Error("applyListOptions() with an invalid pattern succeeded")
+	}
+}
+
+func TestListWithExactTimes(t *testing.T) {
+	listing := "-rw-r--r-- 1 user group 4 Jan 01 2020 file\r\n" +
+		"drwxr-xr-x 2 user group 4096 Jan 01 2020 sub\r\n"
+	c, result := fileServer(map[string]string{
+		"PWD":  `257 "/home"`,
+		"MDTM": "213 20200101123456",
+	}, map[string]string{"dir": listing})
+	entries, err := c.List("dir", ListWithExactTimes(nil, 0))
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2020, 1, 1, 12, 34, 56, 0, time.UTC)
+	if len(entries) != 2 || !entries[0].Time.Equal(want) {
+		t.Errorf("List() = %v, want the time of file from MDTM", entries)
+	}
+	if commands := (<-result).commands; !reflect.DeepEqual(commands, []string{"EPSV", "LIST dir", "PWD", "MDTM /home/dir/file"}) {
+		t.Errorf("commands = %q, want MDTM for the file only", commands)
+	}
+}
+
+func TestListWithExactTimesPool(t *testing.T) {
+	listing := "-rw-r--r-- 1 user group 4 Jan 01 2020 a\r\n" +
+		"-rw-r--r-- 1 user group 4 Jan 01 2020 b\r\n"
+	c, result := fileServer(nil, map[string]string{"/dir": listing})
+	p := NewPool(func() (*ServerConn, error) {
+		pc, _ := scriptedConn(map[string]string{"MDTM": hangUp})
+		return pc, nil
+	}, 1)
+
+	entries, err := c.List("/dir", ListWithExactTimes(p, 1))
+	c.Close()
+	<-result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("List() = %v, want 2 entries", entries)
+	}
+	if stats := p.Stats(); stats.Discards != 1 || stats.Open != 0 {
+		t.Errorf("Stats() = %+v, want the broken connection discarded", stats)
+	}
+}
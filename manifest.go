@@ -0,0 +1,96 @@
+package ftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// ManifestEntry describes a file of a remote tree, as produced by Manifest.
+type ManifestEntry struct {
+	// path relative to the root of the tree
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// hex encoded digest of the content
+	Sum string
+}
+
+// manifestHashes are the algorithms supported by Manifest, by HASH name.
+var manifestHashes = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA-1":   sha1.New,
+	"SHA-256": sha256.New,
+	"SHA-512": sha512.New,
+}
+
+// Manifest walks the remote tree rooted at root like Walk and calls fn with
+// the description of each regular file, including its digest computed with
+// algo ("MD5", "SHA-1", "SHA-256" or "SHA-512"). The digest is computed by
+// the server with HASH if it supports the algorithm, otherwise the file is
+// downloaded through the hash.
+func (c *ServerConn) Manifest(root, algo string, fn func(ManifestEntry) error) error {
+	newHash, ok := manifestHashes[strings.ToUpper(algo)]
+	if !ok {
+		return fmt.Errorf("ftp: unsupported hash algorithm %s", algo)
+	}
+
+	serverHash := false
+	for i, a := range c.HashAlgorithms() {
+		if strings.EqualFold(a, algo) {
+			serverHash = i == 0 || c.SetHashAlgorithm(a) == nil
+			break
+		}
+	}
+
+	p := c.newProgress("Manifest")
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	return c.walkTree(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		var sum string
+		var n int64
+		if serverHash {
+			sum, err = c.Hash(path)
+		} else {
+			var digest []byte
+			n, digest, err = c.retrHash("I", path, ioutil.Discard, newHash())
+			sum = hex.EncodeToString(digest)
+		}
+		if err != nil {
+			return err
+		}
+		p.done(path, n)
+
+		return fn(ManifestEntry{
+			Path:    strings.TrimPrefix(path, prefix),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Sum:     sum,
+		})
+	})
+}
+
+// WriteSums returns a callback for Manifest which writes the entries to w in
+// the format of sha256sum and similar tools: the digest, two spaces and the
+// path, one file per line.
+func WriteSums(w io.Writer) func(ManifestEntry) error {
+	return func(e ManifestEntry) error {
+		_, err := fmt.Fprintf(w, "%s  %s\n", e.Sum, e.Path)
+		return err
+	}
+}
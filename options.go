@@ -0,0 +1,278 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+// DialOption configures Dial.
+type DialOption func(*dialOptions)
+
+// dialOptions holds the settings of the DialOptions: those of the Dialer,
+// and those of the ServerConn, applied once connected.
+type dialOptions struct {
+	dialer Dialer
+	conn   []func(c *ServerConn)
+}
+
+// Dial connects to the FTP server at addr like Connect, configured by the
+// options. The settings of the ServerConn are applied before it is returned,
+// so that they don't race with its use.
+func Dial(addr string, opts ...DialOption) (*ServerConn, error) {
+	return DialContext(context.Background(), addr, opts...)
+}
+
+// DialContext connects like Dial, aborting when ctx is done.
+func DialContext(ctx context.Context, addr string, opts ...DialOption) (*ServerConn, error) {
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c, err := o.dialer.connect(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	o.apply(c)
+	return c, nil
+}
+
+// DialFirst connects to the first of the addresses which accepts a session,
+// like Dialer.ConnectFirst, configured by the options.
+func DialFirst(addrs []string, opts ...DialOption) (*ServerConn, error) {
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c, err := o.dialer.ConnectFirst(addrs...)
+	if err != nil {
+		return nil, err
+	}
+	o.apply(c)
+	return c, nil
+}
+
+// apply applies the settings of the ServerConn.
+func (o *dialOptions) apply(c *ServerConn) {
+	for _, set := range o.conn {
+		set(c)
+	}
+}
+
+// DialWithResolver sets the resolver of the host names, see
+// Dialer.Resolver.
+func DialWithResolver(resolver *net.Resolver) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.Resolver = resolver
+	}
+}
+
+// DialWithDialFunc sets the function opening the control and data
+// connections, see Dialer.DialContext.
+func DialWithDialFunc(dial func(ctx context.Context, network, addr string) (net.Conn, error)) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.DialContext = dial
+	}
+}
+
+// DialWithNetDialer opens the control and data connections with d, e.g. to
+// bind them to a local address.
+func DialWithNetDialer(d *net.Dialer) DialOption {
+	return DialWithDialFunc(d.DialContext)
+}
+
+// DialWithLocalAddr binds the control and data connections to the local
+// address, see Dialer.LocalAddr.
+func DialWithLocalAddr(local *net.TCPAddr) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.LocalAddr = local
+	}
+}
+
+// DialWithSocketHook sets the function setting the socket options of the
+// connections, see Dialer.SocketHook.
+func DialWithSocketHook(hook func(conn *net.TCPConn, data bool) error) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.SocketHook = hook
+	}
+}
+
+// DialWithExplicitTLS secures the connection with AUTH TLS, using config or
+// DefaultTLSConfig if it is nil.
+func DialWithExplicitTLS(config *tls.Config) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.ExplicitTLS = true
+		o.dialer.TLSConfig = config
+	}
+}
+
+// DialWithClientCertificates sets the client certificates presented during
+// the TLS handshake, see Dialer.ClientCertificates.
+func DialWithClientCertificates(certs ...tls.Certificate) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.ClientCertificates = certs
+	}
+}
+
+// DialWithTimeout sets the maximum time of each connection attempt, see
+// Dialer.Timeout.
+func DialWithTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.Timeout = timeout
+	}
+}
+
+// DialWithAttemptTimeout sets the maximum time spent on each address by
+// DialFirst, see Dialer.AttemptTimeout.
+func DialWithAttemptTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.AttemptTimeout = timeout
+	}
+}
+
+// DialWithControlTimeout sets the maximum time of each read and write on the
+// control connection, see ServerConn.ControlTimeout.
+func DialWithControlTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.ControlTimeout = timeout
+	}
+}
+
+// DialWithDataTimeout sets the maximum duration of each data connection,
+// see ServerConn.DataTimeout.
+func DialWithDataTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.DataTimeout = timeout
+	}
+}
+
+// DialWithProxyProtocol sends the PROXY protocol header of the given version
+// on the connections, see Dialer.ProxyProtocol.
+func DialWithProxyProtocol(version int) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.ProxyProtocol = version
+	}
+}
+
+// DialWithPASVAddress selects whether the data connections use the address
+// of the PASV replies, see ServerConn.UsePASVAddress.
+func DialWithPASVAddress(use bool) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.UsePASVAddress = use
+		})
+	}
+}
+
+// DialWithPassiveMode selects the commands used to open passive data
+// connections, see PassiveMode.
+func DialWithPassiveMode(mode PassiveMode) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.PassiveMode = mode
+		})
+	}
+}
+
+// DialWithEPSVAll locks the session to EPSV with EPSV ALL, see
+// ServerConn.UseEPSVAll.
+func DialWithEPSVAll() DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.UseEPSVAll = true
+		})
+	}
+}
+
+// DialWithActiveMode opens the data connections in active mode, listening on
+// a port between portMin and portMax, see ServerConn.ActiveMode.
+func DialWithActiveMode(portMin, portMax int) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.ActiveMode = true
+			c.ActivePortMin = portMin
+			c.ActivePortMax = portMax
+		})
+	}
+}
+
+// DialWithActiveExternalIP advertises ip in active mode instead of the local
+// address, see ServerConn.ActiveExternalIP.
+func DialWithActiveExternalIP(ip net.IP) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.ActiveExternalIP = ip
+		})
+	}
+}
+
+// DialWithDataIdleTimeout sets the maximum time without progress of a
+// transfer, see ServerConn.DataIdleTimeout.
+func DialWithDataIdleTimeout(timeout time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.DataIdleTimeout = timeout
+		})
+	}
+}
+
+// DialWithAutoReconnect reopens the control connection when it breaks, see
+// ServerConn.AutoReconnect.
+func DialWithAutoReconnect() DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.AutoReconnect = true
+		})
+	}
+}
+
+// DialWithDebugOutput copies the traffic of the control connection to w, see
+// Dialer.DebugOutput.
+func DialWithDebugOutput(w io.Writer) DialOption {
+	return func(o *dialOptions) {
+		o.dialer.DebugOutput = w
+	}
+}
+
+// DialWithDisabledEPSV selects whether EPSV is disabled, overriding the
+// detected server profile.
+func DialWithDisabledEPSV(disabled bool) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.DisableEPSV = disabled
+		})
+	}
+}
+
+// DialWithLocation sets the time zone of the LIST timestamps, see
+// ServerConn.Location.
+func DialWithLocation(loc *time.Location) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.Location = loc
+		})
+	}
+}
+
+// DialWithTranslateEncoding selects whether the file names are translated
+// from and to ISO 8859-15 for servers not supporting UTF-8.
+func DialWithTranslateEncoding(translate bool) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.TranslateEncoding = translate
+		})
+	}
+}
+
+// DialWithHistory keeps the last size commands, see ServerConn.History.
+func DialWithHistory(size int) DialOption {
+	return func(o *dialOptions) {
+		o.conn = append(o.conn, func(c *ServerConn) {
+			c.HistorySize = size
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package ftp
 
 import (
+	"os"
 	"testing"
 	"time"
 )
@@ -19,13 +20,19 @@ var listTests = []line{
 	// UNIX ls -l style
 	line{"drwxr-xr-x    3 110      1002            3 Dec 02  2009 pub", "pub", 0, EntryTypeFolder, time.Date(2009, time.December, 2, 0, 0, 0, 0, time.UTC)},
 	line{"drwxr-xr-x    3 110      1002            3 Dec 02  2009 p u b", "p u b", 0, EntryTypeFolder, time.Date(2009, time.December, 2, 0, 0, 0, 0, time.UTC)},
+	line{"-rw-r--r--    1 110      1002            3 Dec 02  2009  two  spaces. ", " two  spaces. ", 3, EntryTypeFile, time.Date(2009, time.December, 2, 0, 0, 0, 0, time.UTC)},
 	line{"-rwxr-xr-x    3 110      1002            1234567 Dec 02  2009 fileName", "fileName", 1234567, EntryTypeFile, time.Date(2009, time.December, 2, 0, 0, 0, 0, time.UTC)},
-	line{"lrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin", "bin -> usr/bin", 0, EntryTypeLink, time.Date(thisYear, time.January, 25, 0, 17, 0, 0, time.UTC)},
+	line{"lrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin", "bin", 0, EntryTypeLink, time.Date(thisYear, time.January, 25, 0, 17, 0, 0, time.UTC)},
 	// Microsoft's FTP servers for Windows
 	line{"----------   1 owner    group         1803128 Jul 10 10:18 ls-lR.Z", "ls-lR.Z", 1803128, EntryTypeFile, time.Date(thisYear, time.July, 10, 10, 18, 0, 0, time.UTC)},
 	line{"d---------   1 owner    group               0 May  9 19:45 Softlib", "Softlib", 0, EntryTypeFolder, time.Date(thisYear, time.May, 9, 19, 45, 0, 0, time.UTC)},
 	// WFTPD for MSDOS
 	line{"-rwxrwxrwx   1 noone    nogroup      322 Aug 19  1996 message.ftp", "message.ftp", 322, EntryTypeFile, time.Date(1996, time.August, 19, 0, 0, 0, 0, time.UTC)},
+	// Special files
+	line{"crw-rw-rw-   1 root     root       1,   3 Jan  1  2020 null", "null", 0, EntryTypeCharDevice, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	line{"brw-rw----   1 root     disk       8,0 Jan  1  2020 sda", "sda", 0, EntryTypeBlockDevice, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	line{"prw-r--r--   1 root     root          0 Jan  1  2020 initctl", "initctl", 0, EntryTypeFifo, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	line{"srwxrwxrwx   1 root     root          0 Jan  1  2020 log", "log", 0, EntryTypeSocket, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
 }
 
 // Not supported, at least we should properly return failure
@@ -35,8 +42,9 @@ var listTestsFail = []line{
 }
 
 func TestParseListLine(t *testing.T) {
+	c := &ServerConn{}
 	for _, lt := range listTests {
-		entry, err := parseListLine(lt.line)
+		entry, err := c.parseListLine(lt.line)
 		if err != nil {
 			t.Errorf("parseListLine(%v) returned err = %v", lt.line, err)
 			continue
@@ -55,9 +63,123 @@ func TestParseListLine(t *testing.T) {
 		}
 	}
 	for _, lt := range listTestsFail {
-		_, err := parseListLine(lt.line)
+		_, err := c.parseListLine(lt.line)
 		if err == nil {
 			t.Errorf("parseListLine(%v) expected to fail", lt.line)
 		}
 	}
 }
+
+func TestParseListLineLink(t *testing.T) {
+	c := &ServerConn{}
+	line := "lrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin"
+	entry, err := c.parseListLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != "bin" || entry.Target != "usr/bin" {
+		t.Errorf("parseListLine() = '%v' -> '%v', want 'bin' -> 'usr/bin'", entry.Name, entry.Target)
+	}
+	if entry.Raw != line {
+		t.Errorf("parseListLine().Raw = '%v', want '%v'", entry.Raw, line)
+	}
+}
+
+func TestParseLsMode(t *testing.T) {
+	modes := map[string]os.FileMode{
+		"-rw-r--r--": 0644,
+		"drwxr-sr-x": 0755 | os.ModeSetgid,
+		"-rwSr--r--": 0644 | os.ModeSetuid,
+		"drwxrwxrwt": 0777 | os.ModeSticky,
+	}
+	for s, want := range modes {
+		if got := parseLsMode(s); got != want {
+			t.Errorf("parseLsMode(%v) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestMListEntry(t *testing.T) {
+	c := &ServerConn{}
+	e, err := c.parseMListLine("type=OS.unix=slink:/usr/bin;UNIX.mode=0777;UNIX.owner=root;modify=20090101000000; bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := e.Entry()
+	if entry.Name != "bin" || entry.Type != EntryTypeLink || entry.Target != "/usr/bin" {
+		t.Errorf("Entry() = %v %v %v, want bin -> /usr/bin", entry.Name, entry.Type, entry.Target)
+	}
+	if entry.Mode != 0777|os.ModeSymlink || entry.Owner != "root" {
+		t.Errorf("Entry().Mode, Owner = %v, %v", entry.Mode, entry.Owner)
+	}
+	if !entry.Time.Equal(time.Date(2009, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Entry().Time = %v", entry.Time)
+	}
+}
+
+func TestMListName(t *testing.T) {
+	c := &ServerConn{}
+	for line, want := range map[string]string{
+		"type=file;size=3;  two  spaces. ": " two  spaces. ",
+		"type=file; semi;colon":            "semi;colon",
+		" no facts":                        "no facts",
+		"type=file; new\x00line":           "new\nline",
+	} {
+		e, err := c.parseMListLine(line)
+		if err != nil {
+			t.Errorf("parseMListLine(%q) returned err = %v", line, err)
+			continue
+		}
+		if e.Name() != want {
+			t.Errorf("parseMListLine(%q).Name() = %q, want %q", line, e.Name(), want)
+		}
+	}
+}
+
+func TestParseListLineLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	c := &ServerConn{Location: loc}
+	entry, err := c.parseListLine("-rwxr-xr-x    3 110      1002            1234567 Dec 02  2009 fileName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2009, time.December, 2, 0, 0, 0, 0, loc); !entry.Time.Equal(want) {
+		t.Errorf("parseListLine().Time = %v, want %v", entry.Time, want)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	msg := "FTP server status:\n" +
+		"     Connected to 192.0.2.1\n" +
+		"     Logged in as ftpuser\n" +
+		"     TYPE: BINARY, STRUcture: File, Mode: Stream\n" +
+		"     No data connection\n" +
+		"     vsFTPd 3.0.3 - secure, fast, stable\n" +
+		"End of status"
+	st := parseStatus(msg)
+	if st.User != "ftpuser" || st.Type != "BINARY" || st.Structure != "File" || st.Mode != "Stream" {
+		t.Errorf("parseStatus() = %+v", st)
+	}
+	if st.DataConnection != "No data connection" || st.Version != "vsFTPd 3.0.3" {
+		t.Errorf("parseStatus() = %+v", st)
+	}
+	if len(st.Lines) != 5 {
+		t.Errorf("parseStatus() returned %d lines, want 5", len(st.Lines))
+	}
+}
+
+func TestParsePathReply(t *testing.T) {
+	for msg, want := range map[string]string{
+		`"/home/user" is the current directory`: "/home/user",
+		`"/say ""hi""" created`:                 `/say "hi"`,
+		`"/"`:                                   "/",
+	} {
+		got, err := parsePathReply(msg)
+		if err != nil || got != want {
+			t.Errorf("parsePathReply(%v) = %v, %v, want %v", msg, got, err, want)
+		}
+	}
+	if _, err := parsePathReply(`"/unterminated`); err == nil {
+		t.Error("parsePathReply() of an unterminated path succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+package ftp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PublicKeyPin returns the pin of the public key of a certificate: the base64
+// encoded SHA-256 digest of its SubjectPublicKeyInfo, as used by HPKP and
+// "openssl x509 -pubkey | openssl pkey -pubin -outform der | openssl dgst
+// -sha256 -binary | base64".
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// PinnedTLSConfig returns a TLS configuration which accepts the server only
+// if the public key of its certificate has one of the pins, whether or not
+// the certificate is signed by a trusted authority. It suits self-signed
+// certificates better than InsecureSkipVerify.
+func PinnedTLSConfig(pins ...string) *tls.Config {
+	config := DefaultTLSConfig()
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("ftp: no server certificate")
+		}
+		pin := PublicKeyPin(cs.PeerCertificates[0])
+		for _, p := range pins {
+			if p == pin {
+				return nil
+			}
+		}
+		return fmt.Errorf("ftp: certificate of %s does not match the pins", cs.ServerName)
+	}
+	return config
+}
+
+// KnownHosts stores the public key pins of the servers trusted on first use.
+type KnownHosts interface {
+	// Lookup returns the pin of host, or false if the host is unknown
+	Lookup(host string) (pin string, ok bool, err error)
+	// Store records the pin of a new host
+	Store(host, pin string) error
+}
+
+// TOFUTLSConfig returns a TLS configuration implementing trust on first use:
+// the public key of a server never seen before is recorded in hosts, and a
+// known server is accepted only if its public key did not change.
+func TOFUTLSConfig(hosts KnownHosts) *tls.Config {
+	config := DefaultTLSConfig()
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("ftp: no server certificate")
+		}
+		pin := PublicKeyPin(cs.PeerCertificates[0])
+		known, ok, err := hosts.Lookup(cs.ServerName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return hosts.Store(cs.ServerName, pin)
+		}
+		if known != pin {
+			return fmt.Errorf("ftp: certificate of %s changed since first use", cs.ServerName)
+		}
+		return nil
+	}
+	return config
+}
+
+// FileKnownHosts is a KnownHosts stored in a text file, one "host pin" line
+// per server. The file is created on the first Store.
+type FileKnownHosts struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Lookup implements the KnownHosts interface.
+func (k *FileKnownHosts) Lookup(host string) (string, bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	f, err := os.Open(k.Path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == host {
+			return fields[1], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// Store implements the KnownHosts interface.
+func (k *FileKnownHosts) Store(host, pin string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	f, err := os.OpenFile(k.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(f, "%s %s\n", host, pin); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,34 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTOFU(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp-tofu-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := TOFUTLSConfig(&FileKnownHosts{Path: filepath.Join(dir, "known_hosts")})
+	state := func(key string) tls.ConnectionState {
+		cert := &x509.Certificate{RawSubjectPublicKeyInfo: []byte(key)}
+		return tls.ConnectionState{ServerName: "ftp.example.com", PeerCertificates: []*x509.Certificate{cert}}
+	}
+
+	if err := config.VerifyConnection(state("key")); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := config.VerifyConnection(state("key")); err != nil {
+		t.Errorf("same key: %v", err)
+	}
+	if err := config.VerifyConnection(state("other key")); err == nil {
+		t.Error("changed key accepted")
+	}
+}
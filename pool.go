@@ -0,0 +1,352 @@
+package ftp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Acquire once the pool has been closed.
+var ErrPoolClosed = errors.New("ftp: pool closed")
+
+// Pool is a set of connections to the same FTP server, so that several
+// goroutines can work with the server concurrently, each one on its own
+// ServerConn.
+//
+// The exported fields must be set before the pool is used.
+type Pool struct {
+	// close connections which stayed idle for longer, zero means never
+	MaxIdleTime time.Duration
+	// maximum number of idle connections kept open, zero means no limit
+	MaxIdle int
+	// number of idle connections opened by WarmUp and kept open beyond
+	// MaxIdleTime (refreshed with NOOP)
+	MinIdle int
+
+	dial func() (*ServerConn, error)
+	// bytes of the data connections, counted by the connections
+	bytes *int64
+	// one token per open or dialing connection
+	sem chan struct{}
+
+	mu      sync.Mutex
+	idle    []idleConn
+	closed  bool
+	reaping bool
+	stats   PoolStats
+}
+
+// PoolStats are statistics of a Pool, returned by Pool.Stats.
+type PoolStats struct {
+	// connections open or being dialed, and idle ones among them
+	Open int
+	Idle int
+	// connections handed out by Acquire, being dialed or refreshed with
+	// NOOP, and not released yet
+	InUse int
+
+	// calls to Acquire, and those which had to wait for a connection to be
+	// released, with the total time waited
+	Acquires int64
+	Waits    int64
+	WaitTime time.Duration
+
+	// connections dialed, dials which failed and connections discarded
+	// because of an error or a failed NOOP
+	Dials        int64
+	DialFailures int64
+	Discards     int64
+	// dial failures and discarded connections per server address, the
+	// address being empty when the dial error does not tell it
+	Failures map[string]int64
+
+	// bytes sent and received on the data connections of the pool, TLS
+	// overhead included
+	Bytes int64
+}
+
+// Stats returns the statistics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Failures = make(map[string]int64, len(p.stats.Failures))
+	for addr, n := range p.stats.Failures {
+		stats.Failures[addr] = n
+	}
+	stats.Bytes = atomic.LoadInt64(p.bytes)
+	// idle connections don't hold a token
+	stats.InUse = len(p.sem)
+	stats.Idle = len(p.idle)
+	stats.Open = stats.InUse + stats.Idle
+	return stats
+}
+
+// idleConn is an idle connection of a Pool.
+type idleConn struct {
+	c     *ServerConn
+	since time.Time
+}
+
+// NewPool returns a pool of at most maxConns connections. dial must return a
+// connected and logged in ServerConn.
+func NewPool(dial func() (*ServerConn, error), maxConns int) *Pool {
+	if maxConns < 1 {
+		maxConns = 1
+	}
+	return &Pool{
+		dial:  dial,
+		bytes: new(int64),
+		sem:   make(chan struct{}, maxConns),
+	}
+}
+
+// Acquire returns an idle connection of the pool, or dials a new one. It
+// blocks while the maximum number of connections is in use. Idle connections
+// are checked with NOOP before being returned.
+//
+// The connection must be handed back with Release, or with Discard if it is
+// not usable anymore.
+func (p *Pool) Acquire() (*ServerConn, error) {
+	var waited time.Duration
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		start := time.Now()
+		p.sem <- struct{}{}
+		waited = time.Since(start)
+	}
+
+	p.mu.Lock()
+	p.stats.Acquires++
+	if waited > 0 {
+		p.stats.Waits++
+		p.stats.WaitTime += waited
+	}
+	p.mu.Unlock()
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.sem
+			return nil, ErrPoolClosed
+		}
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.expired(ic) {
+			ic.c.Quit()
+			continue
+		}
+		if ic.c.NoOp() != nil {
+			ic.c.Quit()
+			p.fail(&p.stats.Discards, ic.c.session.addr)
+			continue
+		}
+		return ic.c, nil
+	}
+
+	c, err := p.dialCounted()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialCounted dials a new connection, updating the statistics.
+func (p *Pool) dialCounted() (*ServerConn, error) {
+	c, err := p.dial()
+	p.count(&p.stats.Dials)
+	if err != nil {
+		var addr string
+		var oe *net.OpError
+		if errors.As(err, &oe) && oe.Addr != nil {
+			addr = oe.Addr.String()
+		}
+		p.fail(&p.stats.DialFailures, addr)
+		return nil, err
+	}
+	c.transferred = p.bytes
+	return c, nil
+}
+
+// count increments a counter of the statistics.
+func (p *Pool) count(counter *int64) {
+	p.mu.Lock()
+	*counter++
+	p.mu.Unlock()
+}
+
+// fail increments a counter of the statistics and the failures of the
+// server address.
+func (p *Pool) fail(counter *int64, addr string) {
+	p.mu.Lock()
+	*counter++
+	if p.stats.Failures == nil {
+		p.stats.Failures = make(map[string]int64)
+	}
+	p.stats.Failures[addr]++
+	p.mu.Unlock()
+}
+
+// Release hands a connection obtained with Acquire back to the pool.
+func (p *Pool) Release(c *ServerConn) {
+	p.mu.Lock()
+	if p.closed || p.MaxIdle > 0 && len(p.idle) >= p.MaxIdle {
+		p.mu.Unlock()
+		c.Quit()
+	} else {
+		p.idle = append(p.idle, idleConn{c, time.Now()})
+		if p.MaxIdleTime > 0 && !p.reaping {
+			p.reaping = true
+			go p.reap()
+		}
+		p.mu.Unlock()
+	}
+	<-p.sem
+}
+
+// expired reports whether an idle connection exceeded MaxIdleTime.
+func (p *Pool) expired(ic idleConn) bool {
+	return p.MaxIdleTime > 0 && time.Since(ic.since) > p.MaxIdleTime
+}
+
+// reap periodically closes the connections idle for longer than
+// MaxIdleTime, until the pool has no idle connection left. The MinIdle most
+// recent ones are refreshed with NOOP instead.
+func (p *Pool) reap() {
+	for {
+		time.Sleep(p.MaxIdleTime / 2)
+
+		// idle is sorted from the oldest to the most recent connection
+		p.mu.Lock()
+		n := 0
+		for n < len(p.idle) && p.expired(p.idle[n]) {
+			n++
+		}
+		expired := append([]idleConn(nil), p.idle[:n]...)
+		p.idle = append(p.idle[:0], p.idle[n:]...)
+		keep := p.MinIdle - len(p.idle)
+		done := p.closed || len(p.idle) == 0 && keep <= 0
+		if done {
+			p.reaping = false
+		}
+		// the expired connections hold a token like those in use until they
+		// are closed or refreshed, so that they are counted and Acquire
+		// does not dial beyond the maximum meanwhile. Idle connections don't
+		// hold one, so a token is only missing while Acquire or Release
+		// hands one over from or to the idle connections: such a connection
+		// is closed without it.
+		var refresh, stale []*ServerConn
+		var held []bool
+		for i := len(expired) - 1; i >= 0; i-- {
+			c := expired[i].c
+			select {
+			case p.sem <- struct{}{}:
+				if keep > 0 && !done {
+					refresh = append(refresh, c)
+					keep--
+					continue
+				}
+				held = append(held, true)
+			default:
+				held = append(held, false)
+			}
+			stale = append(stale, c)
+		}
+		p.mu.Unlock()
+
+		for i, c := range stale {
+			c.Quit()
+			if held[i] {
+				<-p.sem
+			}
+		}
+		for _, c := range refresh {
+			if c.NoOp() != nil {
+				p.Discard(c)
+				continue
+			}
+			p.mu.Lock()
+			closed := p.closed
+			if !closed {
+				p.idle = append(p.idle, idleConn{c, time.Now()})
+			}
+			p.mu.Unlock()
+			if closed {
+				c.Quit()
+			}
+			<-p.sem
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// WarmUp opens and authenticates connections until the pool holds MinIdle
+// idle connections, so that the first requests don't pay for the handshake.
+func (p *Pool) WarmUp() error {
+	for {
+		p.mu.Lock()
+		n := len(p.idle)
+		p.mu.Unlock()
+		if n >= p.MinIdle || n >= cap(p.sem) || p.MaxIdle > 0 && n >= p.MaxIdle {
+			return nil
+		}
+
+		p.sem <- struct{}{}
+		c, err := p.dialCounted()
+		if err != nil {
+			<-p.sem
+			return err
+		}
+		p.Release(c)
+	}
+}
+
+// Discard closes a connection obtained with Acquire instead of handing it
+// back to the pool, e.g. after a network error.
+func (p *Pool) Discard(c *ServerConn) {
+	c.Quit()
+	p.fail(&p.stats.Discards, c.session.addr)
+	<-p.sem
+}
+
+// done hands back a connection after an operation which returned err:
+// replies from the server leave the connection usable, other errors do not.
+func (p *Pool) done(c *ServerConn, err error) {
+	if _, ok := replyError(err); err != nil && !ok {
+		p.Discard(c)
+	} else {
+		p.Release(c)
+	}
+}
+
+// Close closes the idle connections of the pool. Connections in use are
+// closed when they are released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	var err error
+	for _, ic := range idle {
+		if e := ic.c.Quit(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package ftp
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedPool returns a pool dialing scripted connections, and a function
+// returning the commands received by each connection dialed so far.
+func scriptedPool(maxConns int) (*Pool, func() [][]string) {
+	var (
+		mu       sync.Mutex
+		channels []<-chan string
+		received [][]string
+	)
+	p := NewPool(func() (*ServerConn, error) {
+		c, commands := scriptedConn(map[string]string{"NOOP": "200 ok", "QUIT": "221 bye"})
+		mu.Lock()
+		channels = append(channels, commands)
+		received = append(received, nil)
+		mu.Unlock()
+		return c, nil
+	}, maxConns)
+
+	return p, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, commands := range channels {
+			for done := false; !done; {
+				select {
+				case cmd, ok := <-commands:
+					if !ok {
+						done = true
+						break
+					}
+					received[i] = append(received[i], cmd)
+				default:
+					done = true
+				}
+			}
+		}
+		return received
+	}
+}
+
+// waitFor polls cond until it holds or a second elapsed.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestPoolReap(t *testing.T) {
+	p, commands := scriptedPool(2)
+	p.MaxIdleTime = 20 * time.Millisecond
+
+	c1, err := p.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := p.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(c1)
+	p.Release(c2)
+	if stats := p.Stats(); stats.Open != 2 || stats.Idle != 2 {
+		t.Fatalf("Stats() = %+v after Release, want 2 idle connections", stats)
+	}
+
+	if !waitFor(func() bool { return p.Stats().Open == 0 }) {
+		t.Fatalf("Stats() = %+v, want the idle connections to be closed", p.Stats())
+	}
+	waitFor(func() bool {
+		for _, received := range commands() {
+			if len(received) == 0 || received[len(received)-1] != "QUIT" {
+				return false
+			}
+		}
+		return true
+	})
+	for i, received := range commands() {
+		if len(received) == 0 || received[len(received)-1] != "QUIT" {
+			t.Errorf("connection %d received %q, want QUIT", i, received)
+		}
+	}
+	if stats := p.Stats(); stats.Dials != 2 || stats.Discards != 0 {
+		t.Errorf("Stats() = %+v, want 2 dials and no discard", stats)
+	}
+}
+
+func TestPoolMinIdle(t *testing.T) {
+	p, commands := scriptedPool(3)
+	p.MaxIdleTime = 20 * time.Millisecond
+	p.MinIdle = 2
+
+	if err := p.WarmUp(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats.Dials != 2 || stats.Open != 2 || stats.Idle != 2 {
+		t.Fatalf("Stats() = %+v after WarmUp, want 2 idle connections", stats)
+	}
+
+	// the connections outlive MaxIdleTime and are refreshed with NOOP
+	refreshed := func() bool {
+		for _, received := range commands() {
+			if len(received) < 2 {
+				return false
+			}
+		}
+		return true
+	}
+	if !waitFor(refreshed) {
+		t.Fatalf("connections received %q, want NOOP refreshes", commands())
+	}
+	for i, received := range commands() {
+		for _, cmd := range received {
+			if cmd != "NOOP" {
+				t.Errorf("connection %d received %q, want NOOP only", i, received)
+				break
+			}
+		}
+	}
+	// the refreshed connections are counted as in use, never beyond
+	if stats := p.Stats(); stats.Dials != 2 || stats.Open != 2 {
+		t.Errorf("Stats() = %+v, want the 2 connections kept open", stats)
+	}
+
+	c, err := p.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(c)
+	if stats := p.Stats(); stats.Dials != 2 {
+		t.Errorf("Stats() = %+v, want Acquire to reuse an idle connection", stats)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return p.Stats().Open == 0 }) {
+		t.Errorf("Stats() = %+v after Close, want no open connection", p.Stats())
+	}
+}
+
+func TestPoolStatsBytesAndFailures(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 21}, Err: errors.New("refused")}
+	var result <-chan served
+	failing := true
+	p := NewPool(func() (*ServerConn, error) {
+		if failing {
+			return nil, dialErr
+		}
+		var c *ServerConn
+		c, result = fileServer(nil, map[string]string{"file": "Hello, world"})
+		return c, nil
+	}, 1)
+
+	if _, err := p.Acquire(); err != dialErr {
+		t.Fatalf("Acquire() = %v, want the dial error", err)
+	}
+	failing = false
+	c, err := p.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	r.Close()
+	p.Discard(c)
+	<-result
+
+	stats := p.Stats()
+	if stats.Bytes != 12 {
+		t.Errorf("Stats().Bytes = %d, want 12", stats.Bytes)
+	}
+	want := map[string]int64{"192.0.2.1:21": 1, "": 1}
+	if !reflect.DeepEqual(stats.Failures, want) {
+		t.Errorf("Stats().Failures = %v, want %v", stats.Failures, want)
+	}
+}
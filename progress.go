@@ -0,0 +1,52 @@
+package ftp
+
+import "io"
+
+// Progress is the state of a long running operation, reported to
+// ServerConn.OnProgress.
+type Progress struct {
+	// operation in progress: "Walk", "PutFS", "Archive", "Unarchive" or
+	// "Manifest"
+	Op string
+	// number of entries processed so far, and the last one
+	Items int64
+	Path  string
+	// number of bytes transferred so far
+	Bytes int64
+}
+
+// progress reports the progress of an operation to OnProgress.
+type progress struct {
+	c *ServerConn
+	p Progress
+}
+
+// newProgress starts reporting the progress of the operation op.
+func (c *ServerConn) newProgress(op string) *progress {
+	return &progress{c: c, p: Progress{Op: op}}
+}
+
+// done records that the entry path was processed, after transferring n
+// bytes.
+func (p *progress) done(path string, n int64) {
+	if p.c.OnProgress == nil {
+		return
+	}
+	p.p.Items++
+	p.p.Path = path
+	p.p.Bytes += n
+	p.c.OnProgress(p.p)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface.
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	r.n += int64(n)
+	return n, err
+}
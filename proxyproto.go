@@ -0,0 +1,61 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+)
+
+// proxyV2Signature starts the headers of the version 2 of the PROXY protocol.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// writeProxyHeader sends the PROXY protocol header of the given version on
+// conn, describing its local and remote addresses, so that a load balancer
+// can pass the client address on to the server. Connections other than TCP
+// are described as unknown (version 1) or local (version 2).
+func writeProxyHeader(conn net.Conn, version int) error {
+	src, _ := conn.LocalAddr().(*net.TCPAddr)
+	dst, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if src != nil && dst != nil && (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		// mixed families cannot be described
+		src, dst = nil, nil
+	}
+
+	var header []byte
+	switch version {
+	case 1:
+		switch {
+		case src == nil || dst == nil:
+			header = []byte("PROXY UNKNOWN\r\n")
+		case src.IP.To4() != nil:
+			header = []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP, dst.IP, src.Port, dst.Port))
+		default:
+			header = []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", src.IP, dst.IP, src.Port, dst.Port))
+		}
+
+	case 2:
+		header = append(header, proxyV2Signature...)
+		if src == nil || dst == nil {
+			// LOCAL command, unspecified family
+			header = append(header, 0x20, 0x00, 0, 0)
+			break
+		}
+		srcIP, dstIP, family := src.IP.To4(), dst.IP.To4(), byte(0x11)
+		if srcIP == nil {
+			srcIP, dstIP, family = src.IP.To16(), dst.IP.To16(), 0x21
+		}
+		// PROXY command, TCP over IPv4 or IPv6
+		header = append(header, 0x21, family)
+		n := 2*len(srcIP) + 4
+		header = append(header, byte(n>>8), byte(n))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = append(header, byte(src.Port>>8), byte(src.Port))
+		header = append(header, byte(dst.Port>>8), byte(dst.Port))
+
+	default:
+		return fmt.Errorf("ftp: unsupported PROXY protocol version %d", version)
+	}
+
+	_, err := conn.Write(header)
+	return err
+}
@@ -0,0 +1,45 @@
+package ftp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// addrConn is a net.Conn with fixed addresses, recording what is written.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+	buf           bytes.Buffer
+}
+
+func (c *addrConn) LocalAddr() net.Addr           { return c.local }
+func (c *addrConn) RemoteAddr() net.Addr          { return c.remote }
+func (c *addrConn) Write(buf []byte) (int, error) { return c.buf.Write(buf) }
+
+func TestProxyHeader(t *testing.T) {
+	conn := &addrConn{
+		local:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324},
+		remote: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 21},
+	}
+	if err := writeProxyHeader(conn, 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := "PROXY TCP4 192.0.2.1 192.0.2.2 56324 21\r\n"; conn.buf.String() != want {
+		t.Errorf("v1 header = %q, want %q", conn.buf.String(), want)
+	}
+
+	conn.buf.Reset()
+	if err := writeProxyHeader(conn, 2); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"),
+		192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x00, 0x15)
+	if !bytes.Equal(conn.buf.Bytes(), want) {
+		t.Errorf("v2 header = %x, want %x", conn.buf.Bytes(), want)
+	}
+
+	if err := writeProxyHeader(conn, 3); err == nil {
+		t.Error("writeProxyHeader() accepted version 3")
+	}
+}
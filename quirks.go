@@ -0,0 +1,124 @@
+package ftp
+
+import (
+	"strings"
+)
+
+// Quirks lists the workarounds the client applies for a server
+// implementation. Connect enables those of the detected ServerProfile, in
+// addition to the ones set through the options, and they can be overridden
+// through the fields of ServerConn. The built-in profiles
+// only set the quirks known for their implementation, the others are meant
+// for registered profiles and deployments.
+type Quirks struct {
+	// never use EPSV, for servers which accept it but can not be reached on
+	// the announced port, typically because of the network in between
+	// rather than the implementation
+	DisableEPSV bool
+	// list directories by changing to them and issuing a bare MLSD, for
+	// servers which reject "MLSD <path>"; MList falls back to it anyway
+	// after a 500, 501 or 504 reply to MLSD with a path
+	MListChangeDir bool
+	// accept 250 instead of 226 at the end of a transfer
+	TransferComplete250 bool
+	// switch to binary mode before SIZE, for servers which refuse it in
+	// ASCII mode
+	SizeBinaryOnly bool
+	// send the LIST and NLST paths starting with "-" as "./path", for
+	// servers which parse such an argument as ls options
+	ListOptionArgs bool
+	// compare the listed names with the requested ones ignoring case, for
+	// servers with case-insensitive file systems
+	CaseInsensitiveNames bool
+}
+
+// merge enables the quirks enabled in o.
+func (q *Quirks) merge(o Quirks) {
+	q.DisableEPSV = q.DisableEPSV || o.DisableEPSV
+	q.MListChangeDir = q.MListChangeDir || o.MListChangeDir
+	q.TransferComplete250 = q.TransferComplete250 || o.TransferComplete250
+	q.SizeBinaryOnly = q.SizeBinaryOnly || o.SizeBinaryOnly
+	q.ListOptionArgs = q.ListOptionArgs || o.ListOptionArgs
+	q.CaseInsensitiveNames = q.CaseInsensitiveNames || o.CaseInsensitiveNames
+}
+
+// ServerProfile describes a known server implementation and the quirks it
+// needs.
+type ServerProfile struct {
+	Name string
+	Quirks
+	// Match reports whether the server is this implementation, given its
+	// greeting, its SYST reply (empty if SYST failed) and its features.
+	Match func(greeting, system string, features map[string]string) bool
+}
+
+// greetingContains returns a ServerProfile.Match function looking for s in
+// the greeting of the server.
+func greetingContains(s string) func(string, string, map[string]string) bool {
+	return func(greeting, system string, features map[string]string) bool {
+		return strings.Contains(greeting, s)
+	}
+}
+
+// serverProfiles is the registry of known servers, searched in order. The
+// servers emulating ls take the LIST arguments starting with "-" as options,
+// and those running on Windows ignore the case of the names.
+var serverProfiles = []ServerProfile{
+	{Name: "vsFTPd", Quirks: Quirks{ListOptionArgs: true}, Match: greetingContains("vsFTPd")},
+	// ProFTPD answers "550 SIZE not allowed in ASCII mode"
+	{Name: "ProFTPD", Quirks: Quirks{ListOptionArgs: true, SizeBinaryOnly: true}, Match: greetingContains("ProFTPD")},
+	{Name: "FileZilla Server", Quirks: Quirks{CaseInsensitiveNames: true}, Match: greetingContains("FileZilla Server")},
+	// ESTA and ESTP are extensions of Pure-FTPd, whose greeting is often
+	// customized
+	{Name: "Pure-FTPd", Quirks: Quirks{ListOptionArgs: true}, Match: func(greeting, system string, features map[string]string) bool {
+		_, esta := features["ESTA"]
+		_, estp := features["ESTP"]
+		return strings.Contains(greeting, "Pure-FTPd") || esta && estp
+	}},
+	{Name: "Serv-U", Quirks: Quirks{CaseInsensitiveNames: true}, Match: greetingContains("Serv-U")},
+	{Name: "Microsoft FTP Service", Quirks: Quirks{CaseInsensitiveNames: true}, Match: func(greeting, system string, features map[string]string) bool {
+		return strings.Contains(greeting, "Microsoft FTP Service") || strings.HasPrefix(system, "Windows_NT")
+	}},
+	// the z/OS server ends the transfers with "250 Transfer completed
+	// successfully."
+	{Name: "IBM z/OS", Quirks: Quirks{TransferComplete250: true}, Match: func(greeting, system string, features map[string]string) bool {
+		return strings.HasPrefix(system, "MVS")
+	}},
+}
+
+// RegisterServerProfile adds a server profile to the registry used by
+// Connect. Profiles registered later take precedence. It is not safe for
+// concurrent use with Connect and is meant to be called from init functions.
+func RegisterServerProfile(p ServerProfile) {
+	serverProfiles = append([]ServerProfile{p}, serverProfiles...)
+}
+
+// detectProfile identifies the server from its greeting, SYST reply and
+// features, and enables the quirks of the matching profile in addition to
+// those already set.
+func (c *ServerConn) detectProfile() {
+	// SYST is not always allowed before login, the other hints still apply
+	c.system, _ = c.System()
+
+	c.stateMu.Lock()
+	features := make(map[string]string, len(c.features))
+	for name, desc := range c.features {
+		features[name] = desc
+	}
+	c.stateMu.Unlock()
+
+	for _, p := range serverProfiles {
+		if p.Match != nil && p.Match(c.greeting, c.system, features) {
+			c.profile = p.Name
+			c.Quirks.merge(p.Quirks)
+			return
+		}
+	}
+}
+
+// Profile returns the name of the ServerProfile detected by Connect, or an
+// empty string if the server is unknown. The quirks applied for it are
+// available, and can be overridden, through c.Quirks.
+func (c *ServerConn) Profile() string {
+	return c.profile
+}
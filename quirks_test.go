@@ -0,0 +1,59 @@
+package ftp
+
+import "testing"
+
+func TestDetectProfile(t *testing.T) {
+	for _, test := range []struct {
+		greeting, system string
+		features         map[string]string
+		preset           Quirks
+		profile          string
+		quirks           Quirks
+	}{
+		{"220 (vsFTPd 3.0.3)", "215 UNIX Type: L8", nil, Quirks{},
+			"vsFTPd", Quirks{ListOptionArgs: true}},
+		{"220 ProFTPD Server (Debian)", "215 UNIX Type: L8", nil, Quirks{DisableEPSV: true},
+			"ProFTPD", Quirks{DisableEPSV: true, ListOptionArgs: true, SizeBinaryOnly: true}},
+		{"220 Welcome", "215 UNIX Type: L8", map[string]string{"ESTA": "", "ESTP": "", "MLST": "type*;size*;"}, Quirks{},
+			"Pure-FTPd", Quirks{ListOptionArgs: true}},
+		{"220 Microsoft FTP Service", "215 Windows_NT", nil, Quirks{},
+			"Microsoft FTP Service", Quirks{CaseInsensitiveNames: true}},
+		{"220-FTPD1 IBM FTP CS V2R4", "215 MVS is the operating system of this server. FTP Server is running on z/OS.", nil, Quirks{},
+			"IBM z/OS", Quirks{TransferComplete250: true}},
+		{"220 ready", "215 UNIX Type: L8", nil, Quirks{MListChangeDir: true},
+			"", Quirks{MListChangeDir: true}},
+	} {
+		c, _ := scriptedConn(map[string]string{"SYST": test.system})
+		c.greeting = test.greeting
+		for name, desc := range test.features {
+			c.features[name] = desc
+		}
+		c.Quirks = test.preset
+		c.detectProfile()
+		c.Close()
+		if c.Profile() != test.profile || c.Quirks != test.quirks {
+			t.Errorf("%q: profile %q with %+v, want %q with %+v", test.greeting, c.Profile(), c.Quirks, test.profile, test.quirks)
+		}
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	c, commands := scriptedConn(map[string]string{"TYPE": "200 ok", "SIZE": "213 12"})
+	c.transferType = "A"
+	c.Quirks = Quirks{SizeBinaryOnly: true, ListOptionArgs: true, CaseInsensitiveNames: true}
+
+	if size, err := c.FileSize("file"); err != nil || size != 12 {
+		t.Errorf("FileSize() = %v, %v, want 12", size, err)
+	}
+	c.Close()
+	if got := [...]string{<-commands, <-commands}; got != [...]string{"TYPE I", "SIZE file"} {
+		t.Errorf("commands = %q, want TYPE I before SIZE", got)
+	}
+
+	if arg := c.listArg("-file"); arg != "./-file" {
+		t.Errorf("listArg() = %q, want ./-file", arg)
+	}
+	if !c.sameName("README", "readme") {
+		t.Error("sameName() with CaseInsensitiveNames is case sensitive")
+	}
+}
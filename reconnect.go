@@ -0,0 +1,124 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// session is the state of the session restored by a reconnection.
+type session struct {
+	// the Dialer and address of the server, empty for NewConn
+	dialer Dialer
+	addr   string
+	// the last successful authentication, nil if not logged in
+	auth Authenticator
+	// the current directory, tracked with AutoReconnect only
+	dir string
+	// the connection has been closed by Quit or Close
+	closed bool
+}
+
+// canReconnect reports whether err, returned by a command, is caused by a
+// broken control connection which AutoReconnect should reopen.
+func (c *ServerConn) canReconnect(err error) bool {
+	if err == nil || !c.AutoReconnect || c.session.addr == "" || atomic.LoadInt32(&c.reconnecting) != 0 {
+		return false
+	}
+	if _, ok := replyError(err); ok {
+		return false
+	}
+	if errors.Is(err, ErrInvalidArgument) || errors.Is(err, ErrTransferInProgress) || errors.Is(err, ErrResponseTooLarge) {
+		return false
+	}
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+	return !c.session.closed
+}
+
+// reconnect opens a new control connection to the server, secures it again
+// with AuthTLS if needed, logs in again and restores the directory, the
+// transfer type, the data protection and the selected MLSx facts.
+func (c *ServerConn) reconnect() error {
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+	atomic.StoreInt32(&c.reconnecting, 1)
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+
+	nc, err := c.session.dialer.connect(context.Background(), c.session.addr)
+	if err != nil {
+		return err
+	}
+
+	c.cmdMu.Lock()
+	transferType, dataProt, sscn, tlsConfig := c.transferType, c.dataProt, c.sscn, c.tlsConfig
+	c.conn.Close()
+	c.conn, c.netConn, c.limit = nc.conn, nc.netConn, nc.limit
+	c.stateMu.Lock()
+	c.features, c.idleTimeout = nc.features, 0
+	c.stateMu.Unlock()
+	c.greeting, c.system = nc.greeting, nc.system
+	c.tlsConfig, c.dataProt, c.sscn = nc.tlsConfig, nc.dataProt, nc.sscn
+	c.transferType, c.transferring = "", false
+	c.epsvAll, c.epsvRejected = false, false
+	c.cmdMu.Unlock()
+
+	// a session secured by AuthTLS is secured again before logging in, the
+	// credentials are never sent in clear
+	if tlsConfig != nil && c.tlsConfig == nil {
+		if err := c.AuthTLS(tlsConfig); err != nil {
+			c.conn.Close()
+			return err
+		}
+	}
+	if c.session.auth != nil {
+		if err := c.session.auth.Authenticate(c); err != nil {
+			return err
+		}
+	}
+	if sscn {
+		if err := c.SetSSCN(true); err != nil {
+			return err
+		}
+	}
+	if dataProt != "" {
+		if err := c.SetDataProtection(dataProt == "P"); err != nil {
+			return err
+		}
+	}
+	if c.mlstFacts != nil {
+		if err := c.SetMListFacts(c.mlstFacts...); err != nil {
+			return err
+		}
+	}
+	if c.session.dir != "" {
+		if err := c.ChangeDir(c.session.dir); err != nil {
+			return err
+		}
+	}
+	if transferType != "" {
+		return c.setType(transferType)
+	}
+	return nil
+}
+
+// trackDir records the current directory after a change, for AutoReconnect.
+func (c *ServerConn) trackDir() {
+	if !c.AutoReconnect || atomic.LoadInt32(&c.reconnecting) != 0 {
+		return
+	}
+	c.session.dir, _ = c.CurrentDir()
+}
+
+// ErrNotReconnectable is returned by Reconnect when the connection was not
+// opened by a Dialer, e.g. with NewConn.
+var ErrNotReconnectable = errors.New("ftp: connection cannot be reopened")
+
+// Reconnect closes the control connection and opens a new one, restoring
+// the session as AutoReconnect does.
+func (c *ServerConn) Reconnect() error {
+	if c.session.addr == "" {
+		return ErrNotReconnectable
+	}
+	return c.reconnect()
+}
@@ -0,0 +1,147 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// sessionReplies are the replies of a server accepting any login.
+var sessionReplies = map[string]string{
+	"USER": "331 password required",
+	"PASS": "230 logged in",
+	"TYPE": "200 ok",
+	"NOOP": "200 ok",
+	"CWD":  "250 ok",
+	"PWD":  `257 "/data"`,
+	"DELE": "250 ok",
+	"RNFR": "350 ok",
+	"RNTO": "250 ok",
+}
+
+// reconnectingConn returns a logged in connection with AutoReconnect, whose
+// first control connection breaks on the command verb, and the channel of
+// the commands received from then on.
+func reconnectingConn(t *testing.T, verb string) (*ServerConn, <-chan string) {
+	broken := map[string]string{verb: hangUp}
+	for cmd, reply := range sessionReplies {
+		if cmd != verb {
+			broken[cmd] = reply
+		}
+	}
+	commands := make(chan string, 64)
+	dials := 0
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials++
+		replies := sessionReplies
+		if dials == 1 {
+			replies = broken
+		}
+		client, server := net.Pipe()
+		go serveScript(server, "220 ready", replies, commands)
+		return client, nil
+	}
+
+	c, err := Dial("ftp.example.invalid:21", DialWithDialFunc(dial), DialWithAutoReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for len(commands) > 0 {
+		<-commands
+	}
+	return c, commands
+}
+
+func TestAutoReconnect(t *testing.T) {
+	c, commands := reconnectingConn(t, "NOOP")
+	defer c.Close()
+	if err := c.ChangeDir("data"); err != nil {
+		t.Fatal(err)
+	}
+	for len(commands) > 0 {
+		<-commands
+	}
+
+	if err := c.NoOp(); err != nil {
+		t.Fatalf("NoOp() after the connection broke returned err = %v", err)
+	}
+	var got []string
+	for len(commands) > 0 {
+		got = append(got, <-commands)
+	}
+	want := []string{"NOOP", "FEAT", "SYST", "USER user", "PASS secret", "TYPE I", "CWD /data", "NOOP"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestReconnectReplay(t *testing.T) {
+	login := []string{"FEAT", "SYST", "USER user", "PASS secret", "TYPE I"}
+	for _, test := range []struct {
+		verb string
+		op   func(c *ServerConn) error
+		ok   bool
+		want []string
+	}{
+		// DELE may have been executed, it is not issued again
+		{"DELE", func(c *ServerConn) error { return c.Delete("file") }, false,
+			append([]string{"DELE file"}, login...)},
+		// RNTO is issued again only after RNFR
+		{"RNFR", func(c *ServerConn) error { return c.Rename("from", "to") }, true,
+			append(append([]string{"RNFR from"}, login...), "RNFR from", "RNTO to")},
+		{"RNTO", func(c *ServerConn) error { return c.Rename("from", "to") }, false,
+			append([]string{"RNFR from", "RNTO to"}, login...)},
+	} {
+		c, commands := reconnectingConn(t, test.verb)
+		if err := test.op(c); (err == nil) != test.ok {
+			t.Errorf("%s: err = %v, want success %v", test.verb, err, test.ok)
+		}
+		c.Close()
+		var got []string
+		for len(commands) > 0 {
+			got = append(got, <-commands)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: commands = %q, want %q", test.verb, got, test.want)
+		}
+	}
+}
+
+func TestReconnectTLS(t *testing.T) {
+	commands := make(chan string, 64)
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveScript(server, "220 ready", sessionReplies, commands)
+		return client, nil
+	}
+
+	c, err := Dial("ftp.example.invalid:21", DialWithDialFunc(dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	// as if secured by AuthTLS
+	c.tlsConfig = &tls.Config{}
+	for len(commands) > 0 {
+		<-commands
+	}
+
+	if err := c.Reconnect(); err == nil {
+		t.Error("Reconnect() succeeded, want the AUTH TLS error")
+	}
+	var got []string
+	for len(commands) > 0 {
+		got = append(got, <-commands)
+	}
+	if want := []string{"FEAT", "SYST", "AUTH TLS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
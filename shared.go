@@ -0,0 +1,50 @@
+package ftp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SharedConn lets many goroutines share a single ServerConn: the operations
+// submitted with Do are executed one at a time, so that callers need no
+// locking of their own.
+//
+// If Pool is set, operations are spilled over to connections of the pool
+// while more than SpillThreshold operations are waiting for the shared
+// connection. Pool and SpillThreshold must be set before the first call to
+// Do.
+type SharedConn struct {
+	Pool           *Pool
+	SpillThreshold int
+
+	c       *ServerConn
+	mu      sync.Mutex
+	waiting int32
+}
+
+// NewSharedConn returns a SharedConn executing operations on c.
+func NewSharedConn(c *ServerConn) *SharedConn {
+	return &SharedConn{c: c}
+}
+
+// Do executes op with exclusive use of a connection and returns its error.
+// op must not keep the connection, nor any reader returned by it, after it
+// returns.
+func (s *SharedConn) Do(op func(c *ServerConn) error) error {
+	n := atomic.AddInt32(&s.waiting, 1)
+	defer atomic.AddInt32(&s.waiting, -1)
+
+	if s.Pool != nil && int(n) > s.SpillThreshold+1 {
+		c, err := s.Pool.Acquire()
+		if err != nil {
+			return err
+		}
+		err = op(c)
+		s.Pool.done(c, err)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return op(s.c)
+}
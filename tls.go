@@ -0,0 +1,177 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// DefaultTLSConfig returns the TLS configuration used when none is given:
+// TLS 1.2 or later with the default cipher suites of crypto/tls, and the
+// certificate verified against the system roots.
+func DefaultTLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
+
+// setControlConn makes conn the control connection, through the limitReader.
+func (c *ServerConn) setControlConn(conn net.Conn) {
+	c.netConn = conn
+	timed := controlConn{conn, c}
+	c.limit.r = timed
+
+	var r io.Reader = c.limit
+	var w io.Writer = timed
+	if c.debug != nil {
+		r = io.TeeReader(r, c.debug)
+		w = io.MultiWriter(timed, &debugWriter{c.debug})
+	}
+	c.conn = textproto.NewConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{r, w, conn})
+}
+
+// controlConn sets the deadline of each read and write on the control
+// connection according to ServerConn.ControlTimeout.
+type controlConn struct {
+	net.Conn
+	c *ServerConn
+}
+
+// Read implements the io.Reader interface.
+func (t controlConn) Read(buf []byte) (int, error) {
+	if d := t.c.ControlTimeout; d > 0 {
+		t.Conn.SetReadDeadline(time.Now().Add(d))
+	}
+	return t.Conn.Read(buf)
+}
+
+// Write implements the io.Writer interface.
+func (t controlConn) Write(buf []byte) (int, error) {
+	if d := t.c.ControlTimeout; d > 0 {
+		t.Conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	return t.Conn.Write(buf)
+}
+
+// debugWriter copies the commands sent to the debug output, masking the
+// passwords.
+type debugWriter struct {
+	w io.Writer
+}
+
+// Write implements the io.Writer interface.
+func (d *debugWriter) Write(buf []byte) (int, error) {
+	for _, cmd := range []string{"PASS ", "ACCT "} {
+		if bytes.HasPrefix(buf, []byte(cmd)) {
+			d.w.Write([]byte(cmd + "****\r\n"))
+			return len(buf), nil
+		}
+	}
+	return d.w.Write(buf)
+}
+
+// AuthTLS issues an AUTH TLS FTP command and secures the control connection
+// with TLS, before Login. The configuration is used for the data connections
+// too; if it is nil, DefaultTLSConfig is used, and if its ServerName is empty,
+// the host of the server is used as server name. As the server may advertise
+// other features once secured, they are queried again.
+// AUTH TLS is described in RFC 4217
+func (c *ServerConn) AuthTLS(config *tls.Config) error {
+	_, _, err := c.cmd(StatusAuthOK, "AUTH TLS")
+	if err != nil {
+		return opError("AUTH", "", err)
+	}
+
+	if config == nil {
+		config = DefaultTLSConfig()
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = c.host
+	}
+	if config.ClientSessionCache == nil {
+		// lets the data connections resume the session of the control
+		// connection, which many servers require
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	tconn := tls.Client(c.netConn, config)
+	if c.ControlTimeout > 0 {
+		c.netConn.SetDeadline(time.Now().Add(c.ControlTimeout))
+	}
+	if err := tconn.Handshake(); err != nil {
+		return opError("AUTH", "", err)
+	}
+	c.setControlConn(tconn)
+	c.tlsConfig = config
+	c.dataProt = ""
+	c.sscn = false
+
+	return c.refreshFeatures()
+}
+
+// SetDataProtection issues the PBSZ and PROT FTP commands to select whether
+// the data connections are protected with TLS (PROT P) or sent in clear
+// (PROT C). It requires a control connection secured with AuthTLS. Unless
+// it is called, the data connections are protected.
+// PBSZ and PROT are described in RFC 4217
+func (c *ServerConn) SetDataProtection(private bool) error {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	if c.transferring {
+		return opError("PROT", "", ErrTransferInProgress)
+	}
+	return c.setDataProtection(private)
+}
+
+// setDataProtection selects the data protection like SetDataProtection, the
+// caller holding cmdMu.
+func (c *ServerConn) setDataProtection(private bool) error {
+	if c.tlsConfig == nil {
+		return opError("PROT", "", errors.New("ftp: data protection requires AUTH TLS"))
+	}
+	if c.dataProt == "" {
+		// PBSZ must precede the first PROT, with 0 for TLS
+		if _, _, err := c.exchange(StatusCommandOK, "PBSZ 0"); err != nil {
+			return opError("PBSZ", "", err)
+		}
+	}
+
+	level := "C"
+	if private {
+		level = "P"
+	}
+	if _, _, err := c.exchange(StatusCommandOK, "PROT %s", level); err != nil {
+		return opError("PROT", "", err)
+	}
+	c.dataProt = level
+	return nil
+}
+
+// SetSSCN issues a SSCN FTP command to select whether the server acts as TLS
+// client on the protected data connections, instead of the usual TLS server.
+// It allows a server-to-server transfer (FXP) between two FTPS servers, one
+// of them being switched to the client role. While it is on, the TLS
+// configuration of this connection must hold a certificate, as the client
+// acts as TLS server for its own transfers.
+// SSCN is supported by glftpd, drftpd and others.
+func (c *ServerConn) SetSSCN(on bool) error {
+	if _, sscnSupported := c.feature("SSCN"); !sscnSupported {
+		return opError("SSCN", "", errors.New("SSCN not supported by server"))
+	}
+	mode := "OFF"
+	if on {
+		mode = "ON"
+	}
+	if _, _, err := c.cmd(StatusCommandOK, "SSCN %s", mode); err != nil {
+		return opError("SSCN", "", err)
+	}
+	c.sscn = on
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package ftp
+
+import (
+	"errors"
+	"net"
+)
+
+// setTOS is not supported on this platform.
+func setTOS(conn net.Conn, tos int) error {
+	return errors.New("ftp: setting the TOS is not supported on this platform")
+}
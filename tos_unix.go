@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package ftp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setTOS sets the IPv4 TOS or the IPv6 traffic class of a TCP connection.
+func setTOS(conn net.Conn, tos int) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return errors.New("ftp: TOS can only be set on TCP connections")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	level, opt := syscall.IPPROTO_IP, syscall.IP_TOS
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, tos)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,636 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrTransferStalled is returned when no data was transferred on a data
+// connection for longer than the configured stall timeout.
+var ErrTransferStalled = errors.New("ftp: transfer stalled")
+
+// ErrReadBackMismatch is returned by Put and PutReader when the data read
+// back from the uploaded file differs from the data sent.
+var ErrReadBackMismatch = errors.New("ftp: uploaded data does not match the source")
+
+// ErrOverlapMismatch is returned when resuming a transfer and the data
+// already transferred differs from its source, which means that the file
+// changed since the transfer started. It is not retried.
+var ErrOverlapMismatch = errors.New("ftp: resumed data does not match the source")
+
+// RetryPolicy describes how the transfer helpers retry a failed attempt.
+// Permanent negative replies (5xx) from the server are never retried.
+type RetryPolicy struct {
+	// number of attempts, including the first one
+	Attempts int
+	// delay before the first retry
+	Delay time.Duration
+	// factor applied to the delay after each retry, values below 1 keep the
+	// delay constant
+	Backoff float64
+	// upper bound of the delay, zero means no bound
+	MaxDelay time.Duration
+}
+
+// do runs attempt until it succeeds, fails permanently or the attempts are
+// exhausted.
+func (p RetryPolicy) do(attempt func(n int) error) error {
+	return p.doIf(func(err error) bool {
+		return !errors.Is(err, ErrOverlapMismatch) && !isPermanent(err)
+	}, attempt)
+}
+
+// doIf runs attempt until it succeeds, fails with an error retry rejects or
+// the attempts are exhausted.
+func (p RetryPolicy) doIf(retry func(error) bool, attempt func(n int) error) error {
+	var err error
+	delay := p.Delay
+	for n := 0; n == 0 || n < p.Attempts; n++ {
+		if n > 0 {
+			time.Sleep(delay)
+			if p.Backoff > 1 {
+				delay = time.Duration(float64(delay) * p.Backoff)
+			}
+			if p.MaxDelay > 0 && delay > p.MaxDelay {
+				delay = p.MaxDelay
+			}
+		}
+		err = attempt(n)
+		if err == nil || !retry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isTransient reports whether err is a transient negative reply about the
+// data connection or the file, after which the command can be sent again.
+func isTransient(err error) bool {
+	e, ok := replyError(err)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case StatusCanNotOpenDataConnection, StatusTransfertAborted,
+		StatusFileActionIgnored, StatusActionAborted:
+		return true
+	}
+	return false
+}
+
+// isPermanent reports whether err is a permanent negative reply.
+func isPermanent(err error) bool {
+	e, ok := replyError(err)
+	return ok && e.Code >= 500
+}
+
+// GetOptions configures Get. The zero value makes a single attempt, without
+// resume, stall detection nor verification.
+type GetOptions struct {
+	Retry RetryPolicy
+	// continue an existing local file instead of downloading it again;
+	// retries always continue the data received by the previous attempts,
+	// except for ASCII files (see ServerConn.ASCIIExtensions)
+	Resume bool
+	// abort an attempt when no data was received for that long
+	StallTimeout time.Duration
+	// compare the local size with the remote size (SIZE) once done
+	Verify bool
+	// before resuming, compare up to that many bytes at the end of the local
+	// file with the same range of the remote file
+	VerifyOverlap int64
+	// if set, the whole content of the local file, including resumed data,
+	// goes through Hash: once Get returns, Hash.Sum(nil) is its digest
+	Hash hash.Hash
+}
+
+// Get downloads the remote file to the local path, composing retries,
+// resume from the local offset, stall detection and verification as
+// configured by opts, which may be nil. It returns the number of bytes
+// received.
+func (c *ServerConn) Get(remote, local string, opts *GetOptions) (int64, error) {
+	if opts == nil {
+		opts = &GetOptions{}
+	}
+
+	// an ASCII file is downloaded again from its start, as the local size
+	// is not a remote offset
+	ascii := c.isASCII(remote)
+	var total int64
+	err := opts.Retry.do(func(attempt int) error {
+		n, err := c.getAttempt(remote, local, (opts.Resume || attempt > 0) && !ascii, opts)
+		total += n
+		return err
+	})
+	if err != nil || !opts.Verify || ascii {
+		return total, err
+	}
+
+	return total, c.verifySize(remote, local)
+}
+
+// getAttempt downloads the remote file once, appending to the local file if
+// resume is set.
+func (c *ServerConn) getAttempt(remote, local string, resume bool, opts *GetOptions) (int64, error) {
+	// the existing data is read back for VerifyOverlap and Hash
+	flag := os.O_RDWR | os.O_CREATE
+	if !resume {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(local, flag, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if err = c.checkOverlap(remote, f, offset, opts.VerifyOverlap); err != nil {
+		return 0, err
+	}
+
+	var dst io.Writer = f
+	if opts.Hash != nil {
+		opts.Hash.Reset()
+		if _, err = io.Copy(opts.Hash, io.NewSectionReader(f, 0, offset)); err != nil {
+			return 0, err
+		}
+		dst = io.MultiWriter(f, opts.Hash)
+	}
+
+	path := c.toServerEncoding(remote)
+	conn, err := c.cmdTransferFrom(c.typeFor(remote, uint64(offset)), uint64(offset), "RETR %s", path)
+	if err != nil {
+		return 0, opError("RETR", remote, err)
+	}
+
+	r := &response{conn, c}
+	var src io.Reader = r
+	if opts.StallTimeout > 0 {
+		src = &stallConn{Conn: conn, timeout: opts.StallTimeout}
+	}
+	n, err := io.Copy(dst, src)
+	if err2 := r.Close(); err == nil {
+		err = err2
+	}
+	return n, opError("RETR", remote, err)
+}
+
+// RetrHash fetches the specified file like Retr and copies it to w, while
+// computing its digest with h. It returns the number of bytes copied and the
+// digest.
+func (c *ServerConn) RetrHash(path string, w io.Writer, h hash.Hash) (int64, []byte, error) {
+	return c.retrHash(c.typeFor(path, 0), path, w, h)
+}
+
+// retrHash fetches the specified file like RetrHash, with the transfer type t.
+func (c *ServerConn) retrHash(t, path string, w io.Writer, h hash.Hash) (int64, []byte, error) {
+	r, err := c.retr(t, path, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	h.Reset()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err2 := r.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return n, nil, err
+	}
+	return n, h.Sum(nil), nil
+}
+
+// TeeError is returned by RetrTee when some of the destinations failed. The
+// other destinations received the whole file.
+type TeeError struct {
+	// errors of the failed destinations, by index in the RetrTee arguments
+	Errs map[int]error
+}
+
+func (e *TeeError) Error() string {
+	return fmt.Sprintf("ftp: %d destination(s) failed", len(e.Errs))
+}
+
+// RetrTee fetches the specified file like Retr and writes it simultaneously
+// to all the writers, so that the server is read only once. A writer which
+// fails is dropped and the transfer goes on for the others; the download is
+// aborted only once all of them failed. The failed writers are reported with
+// a *TeeError.
+func (c *ServerConn) RetrTee(path string, ws ...io.Writer) (int64, error) {
+	r, err := c.Retr(path)
+	if err != nil {
+		return 0, err
+	}
+
+	t := &teeWriter{ws: ws, errs: make(map[int]error)}
+	n, err := io.Copy(t, r)
+	if err2 := r.Close(); err == nil {
+		err = err2
+	}
+	if err == nil && len(t.errs) > 0 {
+		err = &TeeError{t.errs}
+	}
+	return n, err
+}
+
+// teeWriter duplicates its writes to the writers which have not failed yet.
+type teeWriter struct {
+	ws   []io.Writer
+	errs map[int]error
+}
+
+// Write implements the io.Writer interface, failing only when all writers
+// have failed.
+func (t *teeWriter) Write(buf []byte) (int, error) {
+	for i, w := range t.ws {
+		if _, failed := t.errs[i]; failed {
+			continue
+		}
+		n, err := w.Write(buf)
+		if err == nil && n < len(buf) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			t.errs[i] = err
+		}
+	}
+	if len(t.errs) == len(t.ws) {
+		return 0, &TeeError{t.errs}
+	}
+	return len(buf), nil
+}
+
+// PutOptions configures Put. The zero value makes a single attempt, directly
+// to the remote path, without resume, stall detection nor verification.
+type PutOptions struct {
+	Retry RetryPolicy
+	// continue an existing remote file instead of uploading it again;
+	// retries always continue the data sent by the previous attempts,
+	// except for ASCII files (see ServerConn.ASCIIExtensions)
+	Resume bool
+	// abort an attempt when no data could be sent for that long
+	StallTimeout time.Duration
+	// compare the remote size (SIZE) with the local size once done
+	Verify bool
+	// once done, download that many bytes at the start and at the end of the
+	// remote file, or the whole file if it is smaller than twice that, and
+	// compare them with the data sent
+	ReadBack int64
+	// before resuming, compare up to that many bytes before the resume offset
+	// with the same range of the remote file
+	VerifyOverlap int64
+	// if not empty, upload to the remote path with this suffix appended and
+	// rename the file once complete, so that the remote path never holds a
+	// partial file
+	TempSuffix string
+	// for PutReader with a source which is not an io.Seeker and Retry
+	// allowing several attempts: spool the data to retry from it, in memory
+	// up to SpoolMemory bytes, in a temporary file beyond. Such a source is
+	// always spooled for ReadBack.
+	Spool       bool
+	SpoolMemory int64
+}
+
+// Put uploads the local file to the remote path, composing resume, retries
+// with backoff, atomic rename and verification as configured by opts, which
+// may be nil. It returns the number of bytes sent.
+func (c *ServerConn) Put(local, remote string, opts *PutOptions) (int64, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return c.put(f, remote, opts)
+}
+
+// PutReader uploads the content of r to the remote path like Put. If r is not
+// an io.Seeker, no attempt can be retried nor resumed unless opts.Spool is
+// set, and it is spooled whenever opts.ReadBack is set, to compare the
+// remote file with it once uploaded.
+func (c *ServerConn) PutReader(r io.Reader, remote string, opts *PutOptions) (int64, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return c.put(rs, remote, opts)
+	}
+
+	if opts.ReadBack == 0 && (!opts.Spool || opts.Retry.Attempts < 2) {
+		single := *opts
+		single.Retry = RetryPolicy{}
+		single.Resume = false
+		return c.put(&streamSeeker{r: r}, remote, &single)
+	}
+
+	src, cleanup, err := spool(r, opts.SpoolMemory)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+	return c.put(src, remote, opts)
+}
+
+// spool copies r into memory, up to max bytes, or into a temporary file for
+// larger data. cleanup releases the spooled data.
+func spool(r io.Reader, max int64) (src io.ReadSeeker, cleanup func(), err error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if n <= max {
+		return bytes.NewReader(buf.Bytes()), func() {}, nil
+	}
+
+	f, err := ioutil.TempFile("", "ftp-spool-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err = io.Copy(f, io.MultiReader(&buf, r)); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+// streamSeeker lets a plain io.Reader be uploaded once by put: it only
+// supports seeking to the current position, and to the end once it has been
+// read completely.
+type streamSeeker struct {
+	r    io.Reader
+	read int64
+	eof  bool
+}
+
+// Read implements the io.Reader interface.
+func (s *streamSeeker) Read(buf []byte) (int, error) {
+	n, err := s.r.Read(buf)
+	s.read += int64(n)
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+// Seek implements the io.Seeker interface for the supported positions.
+func (s *streamSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case whence == io.SeekCurrent && offset == 0,
+		whence == io.SeekStart && offset == s.read,
+		whence == io.SeekEnd && offset == 0 && s.eof:
+		return s.read, nil
+	}
+	return 0, errors.New("ftp: source is not seekable")
+}
+
+// put uploads src to the remote path as described by Put.
+func (c *ServerConn) put(src io.ReadSeeker, remote string, opts *PutOptions) (int64, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	target := remote + opts.TempSuffix
+	// an ASCII file is uploaded again from its start, as the remote size is
+	// not a local offset
+	ascii := c.isASCII(remote)
+
+	var total int64
+	err := opts.Retry.do(func(attempt int) error {
+		var offset int64
+		if (opts.Resume || attempt > 0) && !ascii {
+			// a missing remote file simply starts from scratch
+			if size, err := c.FileSize(target); err == nil {
+				offset = size
+			}
+		}
+		if ra, ok := src.(io.ReaderAt); ok {
+			if err := c.checkOverlap(target, ra, offset, opts.VerifyOverlap); err != nil {
+				return err
+			}
+		}
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		// the type depends on the final name, not on the temporary one
+		t := c.typeFor(remote, uint64(offset))
+		n, err := c.putAttempt(src, target, t, offset, opts.StallTimeout)
+		total += n
+		return err
+	})
+	if err != nil {
+		return total, err
+	}
+
+	if opts.Verify && !ascii {
+		size, err := src.Seek(0, io.SeekEnd)
+		if err != nil {
+			return total, err
+		}
+		remoteSize, err := c.FileSize(target)
+		if err != nil {
+			return total, err
+		}
+		if remoteSize != size {
+			return total, fmt.Errorf("ftp: size mismatch for %s: local %d, remote %d", target, size, remoteSize)
+		}
+	}
+
+	if opts.ReadBack > 0 && !ascii {
+		if err := c.readBack(target, src, opts.ReadBack); err != nil {
+			return total, err
+		}
+	}
+
+	if target != remote {
+		err = c.Rename(target, remote)
+	}
+	return total, err
+}
+
+// putAttempt uploads src once with the transfer type t, starting at the
+// remote offset. Resuming uses REST STOR when the server advertises REST
+// STREAM, APPE otherwise.
+func (c *ServerConn) putAttempt(src io.Reader, remote, t string, offset int64, stall time.Duration) (int64, error) {
+	path := c.toServerEncoding(remote)
+
+	var conn net.Conn
+	var err error
+	command := "STOR"
+	if desc, restSupported := c.feature("REST"); offset == 0 || restSupported && desc == "STREAM" {
+		conn, err = c.cmdTransferFrom(t, uint64(offset), "STOR %s", path)
+	} else {
+		command = "APPE"
+		conn, err = c.cmdTransferFrom(t, 0, "APPE %s", path)
+	}
+	if err != nil {
+		return 0, opError(command, remote, err)
+	}
+
+	var dst io.Writer = conn
+	if stall > 0 {
+		dst = &stallConn{Conn: conn, timeout: stall}
+	}
+	n, err := io.Copy(dst, src)
+	conn.Close()
+
+	err2 := c.readTransferComplete()
+	if err == nil {
+		err = err2
+	}
+	return n, opError(command, remote, err)
+}
+
+// checkOverlap compares the n bytes before offset of the local data with the
+// same range of the remote file.
+func (c *ServerConn) checkOverlap(remote string, local io.ReaderAt, offset, n int64) error {
+	if n > offset {
+		n = offset
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	want := make([]byte, n)
+	if _, err := local.ReadAt(want, offset-n); err != nil {
+		return err
+	}
+
+	r, err := c.RetrRange(remote, offset-n, n)
+	if err != nil {
+		return err
+	}
+	got, err := ioutil.ReadAll(r)
+	if err2 := r.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(got, want) {
+		return ErrOverlapMismatch
+	}
+	return nil
+}
+
+// readBack compares the first and last n bytes of the remote file with src,
+// or the whole file if it is smaller than 2*n.
+func (c *ServerConn) readBack(remote string, src io.ReadSeeker, n int64) error {
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	if size <= 2*n {
+		return c.compareRange(remote, src, 0, size)
+	}
+	if err := c.compareRange(remote, src, 0, n); err != nil {
+		return err
+	}
+	return c.compareRange(remote, src, size-n, n)
+}
+
+// compareRange compares n bytes at offset of the remote file with src.
+func (c *ServerConn) compareRange(remote string, src io.ReadSeeker, offset, n int64) error {
+	want := make([]byte, n)
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(src, want); err != nil {
+		return err
+	}
+
+	r, err := c.RetrRange(remote, offset, n)
+	if err != nil {
+		return err
+	}
+	got, err := ioutil.ReadAll(r)
+	if err2 := r.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(got, want) {
+		return ErrReadBackMismatch
+	}
+	return nil
+}
+
+// verifySize compares the size of the local file with the remote one.
+func (c *ServerConn) verifySize(remote, local string) error {
+	remoteSize, err := c.FileSize(remote)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != remoteSize {
+		return fmt.Errorf("ftp: size mismatch for %s: local %d, remote %d", remote, fi.Size(), remoteSize)
+	}
+	return nil
+}
+
+// stallConn pushes the deadlines of a data connection forward before each
+// read and write, without exceeding the deadline of the whole transfer if
+// any, reporting ErrTransferStalled when they expire before it.
+type stallConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// extend sets the deadline of the next read or write.
+func (c *stallConn) extend(set func(time.Time) error) {
+	t := time.Now().Add(c.timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(t) {
+		t = c.deadline
+	}
+	set(t)
+}
+
+// stalled returns ErrTransferStalled if err is a timeout caused by the idle
+// deadline, err otherwise.
+func (c *stallConn) stalled(err error) error {
+	if e, ok := err.(net.Error); ok && e.Timeout() && (c.deadline.IsZero() || time.Now().Before(c.deadline)) {
+		return ErrTransferStalled
+	}
+	return err
+}
+
+// Read implements the io.Reader interface.
+func (c *stallConn) Read(buf []byte) (int, error) {
+	c.extend(c.Conn.SetReadDeadline)
+	n, err := c.Conn.Read(buf)
+	return n, c.stalled(err)
+}
+
+// Write implements the io.Writer interface.
+func (c *stallConn) Write(buf []byte) (int, error) {
+	c.extend(c.Conn.SetWriteDeadline)
+	n, err := c.Conn.Write(buf)
+	return n, c.stalled(err)
+}
@@ -0,0 +1,317 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	for _, max := range []int64{1024, 4} {
+		src, cleanup, err := spool(strings.NewReader(testData), max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err = src.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := ioutil.ReadAll(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != testData {
+				t.Errorf("spool(max=%d) read '%s', want '%s'", max, buf, testData)
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	p := RetryPolicy{Attempts: 3}
+	n := 0
+	err := p.doIf(isTransient, func(int) error {
+		n++
+		if n < 3 {
+			return &textproto.Error{Code: StatusFileActionIgnored, Msg: "try again"}
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Errorf("doIf() = %v after %d attempts, want nil after 3", err, n)
+	}
+
+	n = 0
+	err = p.doIf(isTransient, func(int) error {
+		n++
+		return &textproto.Error{Code: StatusFileUnavailable, Msg: "no such file"}
+	})
+	if err == nil || n != 1 {
+		t.Errorf("doIf() = %v after %d attempts, want 550 after 1", err, n)
+	}
+}
+
+func TestStallConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &stallConn{client, 20 * time.Millisecond, time.Time{}}
+
+	go server.Write([]byte("x")) // then stalls
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(buf); err != ErrTransferStalled {
+		t.Errorf("Read() of a stalled transfer returned err = %v, want %v", err, ErrTransferStalled)
+	}
+}
+
+// served is what a fileServer received once its connection is closed.
+type served struct {
+	commands []string
+	files    map[string]string
+}
+
+// fileServer returns a connection to a scripted server storing files by name:
+// RETR sends a file from the REST offset, STOR writes it from the REST
+// offset and APPE appends to it; LIST and MLSD send a file as the listing.
+func fileServer(replies map[string]string, files map[string]string) (*ServerConn, <-chan served) {
+	script := map[string]string{
+		"TYPE": "200 ok",
+		"EPSV": "229 Entering Extended Passive Mode (|||6446|)",
+		"REST": "350 ok",
+	}
+	for _, cmd := range []string{"RETR", "STOR", "APPE", "LIST", "MLSD"} {
+		script[cmd] = "150 ok\r\n226 done"
+	}
+	for cmd, reply := range replies {
+		script[cmd] = reply
+	}
+
+	control, server := net.Pipe()
+	commands := make(chan string, 64)
+	go func() {
+		serveScript(server, "", script, commands)
+		close(commands)
+	}()
+	c := &ServerConn{limit: &limitReader{}, features: make(map[string]string)}
+	c.setControlConn(control)
+	conns := make(chan net.Conn, 1)
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		conns <- server
+		return client, nil
+	}
+
+	result := make(chan served, 1)
+	go func() {
+		data := make(map[string][]byte)
+		for name, content := range files {
+			data[name] = []byte(content)
+		}
+		var got []string
+		var offset int
+		for cmd := range commands {
+			got = append(got, cmd)
+			verb, name := cmd, ""
+			if i := strings.IndexByte(cmd, ' '); i >= 0 {
+				verb, name = cmd[:i], cmd[i+1:]
+			}
+			switch verb {
+			case "REST":
+				offset, _ = strconv.Atoi(name)
+			case "RETR", "LIST", "MLSD":
+				conn := <-conns
+				conn.Write(data[name][offset:])
+				conn.Close()
+			case "APPE":
+				offset = len(data[name])
+				fallthrough
+			case "STOR":
+				conn := <-conns
+				received, _ := ioutil.ReadAll(conn)
+				data[name] = append(data[name][:offset], received...)
+				conn.Close()
+			}
+			if verb != "REST" {
+				offset = 0
+			}
+		}
+		final := make(map[string]string)
+		for name, content := range data {
+			final[name] = string(content)
+		}
+		result <- served{got, final}
+	}()
+	return c, result
+}
+
+func TestGetResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp-get-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "file")
+
+	for _, test := range []struct {
+		remote   string
+		local    string
+		want     string
+		err      error
+		commands []string
+	}{
+		{"file", "Hello, ", "Hello, world", nil,
+			[]string{"TYPE I", "EPSV", "REST 4", "RETR file", "EPSV", "REST 7", "RETR file"}},
+		{"file", "Hell0, ", "Hell0, ", ErrOverlapMismatch,
+			[]string{"TYPE I", "EPSV", "REST 4", "RETR file"}},
+		// the size of an ASCII file differs on both sides
+		{"file.txt", "Hello, ", "Hello, world", nil,
+			[]string{"TYPE A", "EPSV", "RETR file.txt"}},
+	} {
+		if err := ioutil.WriteFile(local, []byte(test.local), 0666); err != nil {
+			t.Fatal(err)
+		}
+		c, result := fileServer(nil, map[string]string{test.remote: "Hello, world"})
+		c.ASCIIExtensions = []string{".txt"}
+		_, err := c.Get(test.remote, local, &GetOptions{Resume: true, VerifyOverlap: 3, Retry: RetryPolicy{Attempts: 2}})
+		if !errors.Is(err, test.err) {
+			t.Errorf("Get() = %v, want %v", err, test.err)
+		}
+		c.Close()
+		if commands := (<-result).commands; !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("commands = %q, want %q", commands, test.commands)
+		}
+		if got, _ := ioutil.ReadFile(local); string(got) != test.want {
+			t.Errorf("local file = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestPutResume(t *testing.T) {
+	for _, test := range []struct {
+		remote   string
+		want     string
+		err      error
+		commands []string
+	}{
+		{"Hello, ", "Hello, world", nil,
+			[]string{"SIZE file", "TYPE I", "EPSV", "REST 4", "RETR file", "EPSV", "REST 7", "STOR file"}},
+		{"Hell0, ", "Hell0, ", ErrOverlapMismatch,
+			[]string{"SIZE file", "TYPE I", "EPSV", "REST 4", "RETR file"}},
+	} {
+		c, result := fileServer(map[string]string{"SIZE": "213 7"}, map[string]string{"file": test.remote})
+		c.features["REST"] = "STREAM"
+		_, err := c.PutReader(strings.NewReader("Hello, world"), "file", &PutOptions{Resume: true, VerifyOverlap: 3})
+		if !errors.Is(err, test.err) {
+			t.Errorf("PutReader() = %v, want %v", err, test.err)
+		}
+		c.Close()
+		r := <-result
+		if !reflect.DeepEqual(r.commands, test.commands) {
+			t.Errorf("commands = %q, want %q", r.commands, test.commands)
+		}
+		if got := r.files["file"]; got != test.want {
+			t.Errorf("remote file = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	c, result := fileServer(map[string]string{"SIZE": "213 3"}, map[string]string{"file": "Hello, world"})
+
+	if _, err := c.OpenFile("file", os.O_WRONLY); err == nil {
+		t.Error("OpenFile() without O_APPEND nor O_TRUNC succeeded")
+	}
+
+	f, err := c.OpenFile("file", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := f.Seek(0, io.SeekEnd); err != nil || offset != 3 {
+		t.Errorf("Seek() = %v, %v, want 3", offset, err)
+	}
+	if _, err := f.Write([]byte("def")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	r := <-result
+	want := []string{"TYPE I", "EPSV", "STOR file", "EPSV", "STOR file", "SIZE file", "EPSV", "REST 3", "STOR file"}
+	if !reflect.DeepEqual(r.commands, want) {
+		t.Errorf("commands = %q, want %q", r.commands, want)
+	}
+	if got := r.files["file"]; got != "abcdef" {
+		t.Errorf("remote file = %q, want %q", got, "abcdef")
+	}
+}
+
+func TestPutReaderReadBack(t *testing.T) {
+	c, result := fileServer(map[string]string{
+		"SIZE": "213 12",
+		"RNFR": "350 ok",
+		"RNTO": "250 renamed",
+	}, nil)
+	// a source which is not an io.Seeker
+	src := struct{ io.Reader }{strings.NewReader("Hello, world")}
+	_, err := c.PutReader(src, "file", &PutOptions{ReadBack: 4, TempSuffix: ".part"})
+	if err != nil {
+		t.Errorf("PutReader() = %v", err)
+	}
+	c.Close()
+	r := <-result
+	if n := len(r.commands); n == 0 || r.commands[n-1] != "RNTO file" {
+		t.Errorf("commands = %q, want the temporary file renamed", r.commands)
+	}
+	if got := r.files["file.part"]; got != "Hello, world" {
+		t.Errorf("remote file = %q, want %q", got, "Hello, world")
+	}
+}
+
+func TestFileStat(t *testing.T) {
+	c, result := fileServer(map[string]string{
+		"SIZE": "213 12",
+		"MDTM": "213 20200101123456",
+		"RETR": "550 no access",
+	}, nil)
+	f, err := c.OpenFile("dir/file", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2020, 1, 1, 12, 34, 56, 0, time.UTC)
+	if fi.Name() != "file" || fi.Size() != 12 || !fi.ModTime().Equal(want) || fi.IsDir() {
+		t.Errorf("Stat() = %v %v %v, want file of 12 bytes at %v", fi.Name(), fi.Size(), fi.ModTime(), want)
+	}
+
+	var oe *OpError
+	if _, err := f.Read(make([]byte, 1)); !errors.As(err, &oe) || oe.Op != "RETR" || oe.Path != "dir/file" {
+		t.Errorf("Read() = %v, want an *OpError for RETR dir/file", err)
+	}
+	if _, err := f.Seek(0, 42); !errors.As(err, &oe) || oe.Path != "dir/file" {
+		t.Errorf("Seek() = %v, want an *OpError for dir/file", err)
+	}
+	c.Close()
+	<-result
+}
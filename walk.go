@@ -0,0 +1,336 @@
+package ftp
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SkipDir is used as a return value from a WalkFunc to indicate that the
+// directory named in the call is to be skipped.
+var SkipDir = filepath.SkipDir
+
+// WalkFunc is the type of the function called for each file or directory
+// visited by Walk and WalkConcurrent. It follows the semantics of
+// filepath.WalkFunc.
+type WalkFunc func(path string, info os.FileInfo, err error) error
+
+// Walk walks the remote file tree rooted at root, calling fn for each file or
+// directory in the tree, including root. The files are walked in lexical
+// order. Directories are listed with MLSD and symbolic links are not
+// followed.
+func (c *ServerConn) Walk(root string, fn WalkFunc) error {
+	if c.OnProgress == nil {
+		return c.walkTree(root, fn)
+	}
+	p := c.newProgress("Walk")
+	return c.walkTree(root, func(path string, info os.FileInfo, err error) error {
+		p.done(path, 0)
+		return fn(path, info, err)
+	})
+}
+
+// walkTree walks the remote file tree like Walk, without reporting the progress.
+func (c *ServerConn) walkTree(root string, fn WalkFunc) error {
+	info, err := c.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(root, info, c.ReadDir, fn)
+	}
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+// WalkConcurrent walks the remote file tree rooted at root like Walk, but
+// lists the directories ahead of fn on up to workers connections of the
+// pool. fn is called sequentially and in the same order as Walk, so the
+// result is deterministic. The subdirectories of a directory are listed once
+// fn accepted it, so that SkipDir prunes them; their listings are kept in
+// memory until walked, which costs up to the listings of all the
+// subdirectories of the directories being walked.
+func WalkConcurrent(p *Pool, root string, workers int, fn WalkFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	c, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	info, err := c.Lstat(root)
+	p.done(c, err)
+	if err != nil {
+		err = fn(root, nil, err)
+		if err == SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	l := &prefetcher{p: p, dirs: make(map[string]*dirListing)}
+	l.cond = sync.NewCond(&l.mu)
+	// the walking goroutine lists itself the directories not started yet
+	for i := 1; i < workers; i++ {
+		l.wg.Add(1)
+		go l.work()
+	}
+	defer l.stop()
+
+	err = walk(root, info, l.readDir, func(path string, info os.FileInfo, err error) error {
+		err = fn(path, info, err)
+		if info != nil && info.IsDir() {
+			l.walked(path, err == nil)
+		}
+		return err
+	})
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+// dirListing is the listing of a directory by a prefetcher.
+type dirListing struct {
+	dir     string
+	started bool
+	ready   chan struct{}
+	entries []os.FileInfo
+	err     error
+}
+
+// list lists the directory on a connection of the pool.
+func (d *dirListing) list(p *Pool) {
+	defer close(d.ready)
+	c, err := p.Acquire()
+	if err != nil {
+		d.err = err
+		return
+	}
+	d.entries, d.err = c.ReadDir(d.dir)
+	p.done(c, d.err)
+}
+
+// prefetcher lists the directories queued by WalkConcurrent ahead of the
+// walk.
+type prefetcher struct {
+	p       *Pool
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    map[string]*dirListing
+	queue   []*dirListing
+	stopped bool
+}
+
+// work lists the queued directories until the prefetcher is stopped.
+func (l *prefetcher) work() {
+	defer l.wg.Done()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		for len(l.queue) == 0 && !l.stopped {
+			l.cond.Wait()
+		}
+		if l.stopped {
+			return
+		}
+		d := l.queue[0]
+		l.queue = l.queue[1:]
+		if d.started {
+			continue
+		}
+		d.started = true
+		l.mu.Unlock()
+		d.list(l.p)
+		l.mu.Lock()
+	}
+}
+
+// readDir returns the listing of dir, waiting for it if a worker started it
+// or listing it otherwise.
+func (l *prefetcher) readDir(dir string) ([]os.FileInfo, error) {
+	l.mu.Lock()
+	d := l.dirs[dir]
+	if d == nil {
+		d = &dirListing{dir: dir, ready: make(chan struct{})}
+		l.dirs[dir] = d
+	}
+	if d.started {
+		l.mu.Unlock()
+		<-d.ready
+	} else {
+		d.started = true
+		l.mu.Unlock()
+		d.list(l.p)
+	}
+	return d.entries, d.err
+}
+
+// walked forgets the listing of dir once fn was called for it, and queues its
+// subdirectories if fn accepted it.
+func (l *prefetcher) walked(dir string, accepted bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := l.dirs[dir]
+	delete(l.dirs, dir)
+	if d == nil || !accepted {
+		return
+	}
+
+	entries := append([]os.FileInfo(nil), d.entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if isSubdir(e) {
+			sub := &dirListing{dir: path.Join(dir, e.Name()), ready: make(chan struct{})}
+			l.dirs[sub.dir] = sub
+			l.queue = append(l.queue, sub)
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// stop ends the workers, once their current listing is done.
+func (l *prefetcher) stop() {
+	l.mu.Lock()
+	l.stopped = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	l.wg.Wait()
+}
+
+// DiskUsage is the size of a remote file tree, as computed by Du.
+type DiskUsage struct {
+	// total size of the files
+	Bytes int64
+	// number of files and directories, -1 if the server computed the size
+	Files int64
+	Dirs  int64
+}
+
+// Du computes the disk usage of the remote file tree rooted at root. If the
+// server supports DSIZ, it computes the size itself; otherwise the tree is
+// walked and progress, if not nil, is called with the usage so far each time
+// a directory is entered. The walk stops when ctx is done.
+func (c *ServerConn) Du(ctx context.Context, root string, progress func(DiskUsage)) (DiskUsage, error) {
+	if _, dsizSupported := c.feature("DSIZ"); dsizSupported {
+		_, msg, err := c.cmd(StatusFile, "DSIZ %s", c.toServerEncoding(root))
+		if err == nil {
+			size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+			if err == nil {
+				return DiskUsage{Bytes: size, Files: -1, Dirs: -1}, nil
+			}
+		}
+		// fall back to walking the tree
+	}
+
+	var usage DiskUsage
+	err := c.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			usage.Dirs++
+			if progress != nil {
+				progress(usage)
+			}
+			return nil
+		}
+		usage.Files++
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// Find walks the remote file tree rooted at root like Walk and calls found
+// for each file or directory for which match returns true, as soon as it is
+// listed. Directories below root which the server refuses to list are
+// skipped, while a root which cannot be read or a network error stops the
+// search with the error; found may return SkipDir to skip a matching
+// directory, or any other error to stop.
+func (c *ServerConn) Find(root string, match func(path string, e *Entry) bool, found func(path string, e *Entry) error) error {
+	return c.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if _, ok := replyError(err); ok && path != root && info != nil && info.IsDir() {
+				return SkipDir
+			}
+			return err
+		}
+		e := fileInfoEntry(info)
+		if !match(path, e) {
+			return nil
+		}
+		return found(path, e)
+	})
+}
+
+// fileInfoEntry returns the Entry described by the FileInfo returned by
+// ReadDir or Lstat.
+func fileInfoEntry(info os.FileInfo) *Entry {
+	switch fi := info.(type) {
+	case EntryEx:
+		return fi.Entry()
+	case entryInfo:
+		return fi.e
+	}
+	return &Entry{
+		Name: info.Name(),
+		Size: uint64(info.Size()),
+		Time: info.ModTime(),
+		Mode: info.Mode(),
+	}
+}
+
+// walk recursively descends name, calling fn.
+func walk(name string, info os.FileInfo, readDir func(string) ([]os.FileInfo, error), fn WalkFunc) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+
+	entries, err := readDir(name)
+	err1 := fn(name, info, err)
+	if err != nil || err1 != nil {
+		// the caller decides whether to skip the directory or stop
+		return err1
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if e.IsDir() && !isSubdir(e) {
+			continue
+		}
+		err = walk(path.Join(name, e.Name()), e, readDir, fn)
+		if err != nil && (!e.IsDir() || err != SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSubdir reports whether a listed entry is a sub directory, and not the
+// listed directory itself or its parent.
+func isSubdir(fi os.FileInfo) bool {
+	if !fi.IsDir() {
+		return false
+	}
+	if e, ok := fi.(EntryEx); ok {
+		switch strings.ToLower(e.Facts["type"]) {
+		case "cdir", "pdir":
+			return false
+		}
+	}
+	return fi.Name() != "." && fi.Name() != ".."
+}
@@ -0,0 +1,131 @@
+package ftp
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testEntry(name, eType string) EntryEx {
+	return EntryEx{name: name, Facts: map[string]string{"type": eType}}
+}
+
+func TestWalkOrder(t *testing.T) {
+	tree := map[string][]os.FileInfo{
+		"/":      {testEntry("b", "file"), testEntry(".", "cdir"), testEntry("a", "dir"), testEntry("skip", "dir")},
+		"/a":     {testEntry("z", "file"), testEntry("..", "pdir"), testEntry("y", "file")},
+		"/skip":  {testEntry("never", "file")},
+		"/other": nil,
+	}
+	readDir := func(dir string) ([]os.FileInfo, error) {
+		return tree[dir], nil
+	}
+
+	var visited []string
+	err := walk("/", testEntry("/", "dir"), readDir, func(path string, info os.FileInfo, err error) error {
+		visited = append(visited, path)
+		if path == "/skip" {
+			return SkipDir
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/", "/a", "/a/y", "/a/z", "/b", "/skip"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("walk visited %v, want %v", visited, want)
+	}
+}
+
+func TestWalkConcurrent(t *testing.T) {
+	tree := map[string]string{
+		"/":          "type=file; b\r\ntype=dir; a\r\ntype=dir; skip\r\n",
+		"/a":         "type=file; y\r\ntype=dir; c\r\n",
+		"/a/c":       "type=file; z\r\n",
+		"/skip":      "type=dir; deep\r\n",
+		"/skip/deep": "type=file; never\r\n",
+	}
+	var (
+		mu      sync.Mutex
+		results []<-chan served
+	)
+	p := NewPool(func() (*ServerConn, error) {
+		c, result := fileServer(map[string]string{"MLST": "250-Listing\r\n type=dir; /\r\n250 End"}, tree)
+		mu.Lock()
+		results = append(results, result)
+		mu.Unlock()
+		return c, nil
+	}, 3)
+
+	var visited []string
+	err := WalkConcurrent(p, "/", 3, func(path string, info os.FileInfo, err error) error {
+		visited = append(visited, path)
+		if path == "/skip" {
+			return SkipDir
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a", "/a/c", "/a/c/z", "/a/y", "/b", "/skip"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("WalkConcurrent visited %v, want %v", visited, want)
+	}
+
+	if open := p.Stats().Open; open < 1 || open > 3 {
+		t.Errorf("%d connections open, want 1 to 3", open)
+	}
+	p.Close()
+	var listed []string
+	for _, result := range results {
+		for _, cmd := range (<-result).commands {
+			if strings.HasPrefix(cmd, "MLSD ") {
+				listed = append(listed, cmd[5:])
+			}
+		}
+	}
+	sort.Strings(listed)
+	if want := []string{"/", "/a", "/a/c", "/skip"}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+}
+
+func TestFind(t *testing.T) {
+	tree := map[string]string{
+		"/":  "type=dir; a\r\ntype=dir; bad\r\n",
+		"/a": "type=file; x\r\n",
+	}
+	c, result := fileServer(map[string]string{
+		"MLST /":     "250-Listing\r\n type=dir; /\r\n250 End",
+		"MLST /gone": "550 not found",
+		"MLSD /bad":  "550 permission denied",
+	}, tree)
+
+	var found []string
+	err := c.Find("/", func(path string, e *Entry) bool { return true }, func(path string, e *Entry) error {
+		found = append(found, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/", "/a", "/a/x"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("Find found %v, want %v", found, want)
+	}
+
+	err = c.Find("/gone", func(path string, e *Entry) bool { return true }, func(path string, e *Entry) error {
+		t.Errorf("found %s under a missing root", path)
+		return nil
+	})
+	if err == nil {
+		t.Error("Find() under a missing root succeeded")
+	}
+	c.Close()
+	<-result
+}